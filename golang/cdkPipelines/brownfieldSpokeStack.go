@@ -0,0 +1,186 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cdkPipelines
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	ec2 "github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
+	"github.com/aws/constructs-go/constructs/v10"
+	"github.com/aws/jsii-runtime-go"
+)
+
+type BrownfieldSpokeStackProps struct {
+	awscdk.StackProps
+	spoke       BrownfieldSpokeConfig
+	transitGWId *string
+}
+
+// Attaches an existing VPC to the transit gateway as a spoke. The VPC itself
+// is imported and left untouched, only the attachment subnets (when created
+// here), the attachment and the routes to the transit gateway are managed.
+// The VPC must be in the account and region of the stack.
+func BrownfieldSpokeStack(scope constructs.Construct, id string, props *BrownfieldSpokeStackProps) InspectionWorkloadStackOutputs {
+	if props == nil {
+		panic(fmt.Sprintf("brownfield spoke stack %s: props with the spoke config are required", id))
+	}
+	spoke := props.spoke
+	validateBrownfieldSpoke(spoke)
+
+	stack := awscdk.NewStack(scope, &id, &props.StackProps)
+
+	var vpc ec2.IVpc
+	if len(spoke.availabilityZones) == 0 {
+		// Resolved at synth time and cached in cdk.context.json, commit it so
+		// the pipeline does not need to look the VPC up.
+		vpc = ec2.Vpc_FromLookup(stack, jsii.String("vpc"), &ec2.VpcLookupOptions{
+			VpcId: jsii.String(spoke.vpcId),
+		})
+	} else {
+		vpc = ec2.Vpc_FromVpcAttributes(stack, jsii.String("vpc"), &ec2.VpcAttributes{
+			VpcId:             jsii.String(spoke.vpcId),
+			AvailabilityZones: jsii.Strings(spoke.availabilityZones...),
+		})
+	}
+
+	attachmentSubnetIds := jsii.Strings(spoke.attachmentSubnetIds...)
+	availabilityZones := vpc.AvailabilityZones()
+	if len(spoke.attachmentSubnetIds) == 0 {
+		// One dedicated /28 per AZ, so the attachment ENIs do not take
+		// addresses from the workload subnets and get their own route table.
+		attachmentSubnetIds = &[]*string{}
+		var azs []*string
+		for i, cidr := range spoke.attachmentSubnetCidrs {
+			// Only known here when the VPC is looked up.
+			if i >= len(*availabilityZones) {
+				awscdk.Annotations_Of(stack).AddError(jsii.String(fmt.Sprintf(
+					"brownfield spoke %s: more attachment subnet CIDRs than the %d AZs of %s",
+					spoke.name, len(*availabilityZones), spoke.vpcId)))
+				break
+			}
+			az := (*availabilityZones)[i]
+			subnet := ec2.NewPrivateSubnet(stack, jsii.String(fmt.Sprintf("TgwAttachmentSubnet%d", i+1)), &ec2.PrivateSubnetProps{
+				VpcId:            vpc.VpcId(),
+				AvailabilityZone: az,
+				CidrBlock:        jsii.String(cidr),
+			})
+			*attachmentSubnetIds = append(*attachmentSubnetIds, subnet.SubnetId())
+			azs = append(azs, az)
+		}
+		availabilityZones = &azs
+	}
+
+	segment := spoke.segment
+	if segment == "" {
+		segment = "workload"
+	}
+
+	tGWAttachment := ec2.NewCfnTransitGatewayAttachment(stack, jsii.String("TGW_Attachment"), &ec2.CfnTransitGatewayAttachmentProps{
+		TransitGatewayId: props.transitGWId,
+		SubnetIds:        attachmentSubnetIds,
+		VpcId:            vpc.VpcId(),
+		Tags: &[]*awscdk.CfnTag{
+			{
+				Key:   jsii.String("routeTable"),
+				Value: jsii.String(segment),
+			},
+		},
+	})
+
+	// An existing default route has to be removed first, CloudFormation does
+	// not replace routes it does not manage and fails with
+	// RouteAlreadyExists.
+	for _, routeTableId := range spoke.routeTableIds {
+		ec2.NewCfnRoute(stack, jsii.String(fmt.Sprintf("Default-route-%s", routeTableId)), &ec2.CfnRouteProps{
+			RouteTableId:         jsii.String(routeTableId),
+			DestinationCidrBlock: jsii.String("0.0.0.0/0"),
+			TransitGatewayId:     props.transitGWId,
+		}).AddDependency(tGWAttachment)
+	}
+
+	awscdk.NewCfnOutput(stack, jsii.String("tgw-attachment-output"), &awscdk.CfnOutputProps{
+		Value:       tGWAttachment.AttrId(),
+		Description: jsii.String(fmt.Sprintf("Transit gateway attachment of %s", spoke.vpcId)),
+	})
+
+	var outputs InspectionWorkloadStackOutputs
+	outputs.Stack = stack
+	outputs.account = stackAccount(stack)
+	outputs.vpcId = vpc.VpcId()
+	outputs.availabilityZones = availabilityZones
+
+	return outputs
+}
+
+// Checks the spoke config before anything is created, so a mistake fails
+// the synth with a message instead of a half-built stack.
+func validateBrownfieldSpoke(spoke BrownfieldSpokeConfig) {
+	fail := func(format string, args ...interface{}) {
+		panic(fmt.Sprintf("brownfield spoke %s: ", spoke.name) + fmt.Sprintf(format, args...))
+	}
+
+	if spoke.name == "" {
+		panic("brownfield spoke: name is required")
+	}
+	if !strings.HasPrefix(spoke.vpcId, "vpc-") {
+		fail("invalid vpcId %q", spoke.vpcId)
+	}
+
+	switch {
+	case len(spoke.attachmentSubnetIds) > 0 && len(spoke.attachmentSubnetCidrs) > 0:
+		fail("set either attachmentSubnetIds or attachmentSubnetCidrs, not both")
+	case len(spoke.attachmentSubnetIds) > 0:
+		for _, id := range spoke.attachmentSubnetIds {
+			if !strings.HasPrefix(id, "subnet-") {
+				fail("invalid attachment subnet ID %q", id)
+			}
+		}
+	case len(spoke.attachmentSubnetCidrs) > 0:
+		for _, cidr := range spoke.attachmentSubnetCidrs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				fail("invalid attachment subnet CIDR %q", cidr)
+			}
+		}
+		if len(spoke.availabilityZones) > 0 && len(spoke.attachmentSubnetCidrs) > len(spoke.availabilityZones) {
+			fail("more attachment subnet CIDRs than availabilityZones")
+		}
+	default:
+		fail("set attachmentSubnetIds or attachmentSubnetCidrs")
+	}
+
+	seen := map[string]bool{}
+	for _, id := range spoke.routeTableIds {
+		if !strings.HasPrefix(id, "rtb-") {
+			fail("invalid route table ID %q", id)
+		}
+		if seen[id] {
+			fail("route table %s is listed twice", id)
+		}
+		seen[id] = true
+	}
+
+	segment := spoke.segment
+	if segment == "" {
+		segment = "workload"
+	}
+	if segment == "inspection" {
+		fail("spokes cannot join the inspection segment")
+	}
+	lookupSegment(segment)
+}
@@ -0,0 +1,93 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cdkPipelines
+
+import "testing"
+
+func TestValidateBrownfieldSpoke(t *testing.T) {
+	valid := BrownfieldSpokeConfig{
+		name:                  "Legacy",
+		vpcId:                 "vpc-0123456789abcdef0",
+		availabilityZones:     []string{"eu-west-1a", "eu-west-1b"},
+		attachmentSubnetCidrs: []string{"10.1.255.0/28", "10.1.255.16/28"},
+		routeTableIds:         []string{"rtb-1", "rtb-2"},
+	}
+
+	tests := []struct {
+		name      string
+		change    func(spoke *BrownfieldSpokeConfig)
+		wantPanic bool
+	}{
+		{name: "valid", change: func(spoke *BrownfieldSpokeConfig) {}},
+		{
+			name: "existing attachment subnets",
+			change: func(spoke *BrownfieldSpokeConfig) {
+				spoke.attachmentSubnetCidrs = nil
+				spoke.attachmentSubnetIds = []string{"subnet-1", "subnet-2"}
+			},
+		},
+		{name: "missing name", change: func(spoke *BrownfieldSpokeConfig) { spoke.name = "" }, wantPanic: true},
+		{name: "missing VPC", change: func(spoke *BrownfieldSpokeConfig) { spoke.vpcId = "" }, wantPanic: true},
+		{
+			name:      "no attachment subnets",
+			change:    func(spoke *BrownfieldSpokeConfig) { spoke.attachmentSubnetCidrs = nil },
+			wantPanic: true,
+		},
+		{
+			name:      "subnet IDs and CIDRs",
+			change:    func(spoke *BrownfieldSpokeConfig) { spoke.attachmentSubnetIds = []string{"subnet-1"} },
+			wantPanic: true,
+		},
+		{
+			name:      "invalid CIDR",
+			change:    func(spoke *BrownfieldSpokeConfig) { spoke.attachmentSubnetCidrs = []string{"10.1.255.0"} },
+			wantPanic: true,
+		},
+		{
+			name: "more CIDRs than AZs",
+			change: func(spoke *BrownfieldSpokeConfig) {
+				spoke.attachmentSubnetCidrs = append(spoke.attachmentSubnetCidrs, "10.1.255.32/28")
+			},
+			wantPanic: true,
+		},
+		{
+			name:      "duplicate route table",
+			change:    func(spoke *BrownfieldSpokeConfig) { spoke.routeTableIds = []string{"rtb-1", "rtb-1"} },
+			wantPanic: true,
+		},
+		{
+			name:      "invalid route table",
+			change:    func(spoke *BrownfieldSpokeConfig) { spoke.routeTableIds = []string{"subnet-1"} },
+			wantPanic: true,
+		},
+		{name: "unknown segment", change: func(spoke *BrownfieldSpokeConfig) { spoke.segment = "prod" }, wantPanic: true},
+		{name: "inspection segment", change: func(spoke *BrownfieldSpokeConfig) { spoke.segment = "inspection" }, wantPanic: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withSegments(t, exampleSegments[:2], nil, false)
+			spoke := valid
+			tt.change(&spoke)
+			defer func() {
+				if r := recover(); (r != nil) != tt.wantPanic {
+					t.Errorf("validateBrownfieldSpoke panic = %v, want panic %v", r, tt.wantPanic)
+				}
+			}()
+			validateBrownfieldSpoke(spoke)
+		})
+	}
+}
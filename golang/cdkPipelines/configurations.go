@@ -25,3 +25,384 @@ var OrganizationCidr = "10.0.0.0/8"
 
 var HubEnv = awscdk.Environment{Account: &hubAccountId, Region: jsii.String("eu-central-1")}
 var SpokeEnv = awscdk.Environment{Account: &hubAccountId, Region: jsii.String("eu-central-1")}
+
+// AZs of the inspection VPC and of the spokes. Spoke AZs must be a subset of
+// the hub AZs so every spoke has a firewall endpoint in the same AZ.
+var HubAzs = VpcAzConfig{}
+var SpokeAzs = VpcAzConfig{maxAzs: 2}
+
+type Ipv6Config struct {
+	// IPAM pool the IPv6 blocks of the VPCs are allocated from,
+	// Amazon-provided /56 blocks when empty.
+	ipamPoolId string
+	// Netmask of the blocks allocated from the IPAM pool, defaults to /56.
+	netmaskLength float64
+	// Summary of the IPv6 blocks of all VPCs, e.g. the CIDR of the IPAM pool.
+	// Needed for the egress-only path of the inspection VPC and for the IPv6
+	// firewall rules, and the only IPv6 destination the spokes route to the
+	// transit gateway. The hub gives the spokes no IPv6 internet access.
+	// Without it, the spokes route ::/0, IPv6 traffic leaving the firewall
+	// always goes back to the transit gateway and IPv6 internet traffic of
+	// the spokes is dropped, which synth warns about.
+	organizationCidr string
+}
+
+// Dual-stack inspection and workload VPCs, IPv4 only when nil.
+var DualStack *Ipv6Config
+
+// Resources deployed into the sample spokes: NoWorkload for networking only,
+// TestInstanceWorkload for a probe instance, an EcsServiceWorkload such as
+// EcsServiceWorkload{image: "public.ecr.aws/nginx/nginx:latest", containerPort: 80, desiredCount: 1},
+// or a WorkloadFunc building any other construct.
+var SpokeWorkload WorkloadTemplate = TestInstanceWorkload{}
+
+// Attach the sample spokes through dedicated /28 subnets. Switching an
+// existing spoke moves its attachment to the new subnets.
+var SpokeDedicatedAttachmentSubnets = false
+
+type BrownfieldSpokeConfig struct {
+	// Stack ID suffix, e.g. "Legacy" for DeployInspection-Spoke-Legacy.
+	name  string
+	vpcId string
+	// AZs of the VPC. The VPC is looked up at synth time when empty, which
+	// needs credentials for the hub account.
+	availabilityZones []string
+	// Existing subnets to attach, one per AZ.
+	attachmentSubnetIds []string
+	// Free /28 CIDRs in the VPC for dedicated attachment subnets, one per AZ
+	// in order. Used when attachmentSubnetIds is empty.
+	attachmentSubnetCidrs []string
+	// Route tables whose default route goes to the transit gateway. They
+	// must not have a 0.0.0.0/0 route yet, remove it before deploying or the
+	// stack fails with RouteAlreadyExists.
+	routeTableIds []string
+	// Segment the attachment is associated with, defaults to "workload".
+	segment string
+}
+
+// Existing VPCs attached as spokes without being recreated. Their stacks are
+// deployed to the hub account and region, so the VPCs must live there.
+var BrownfieldSpokes = []BrownfieldSpokeConfig{}
+
+// AZ ID to AZ name mapping per account, e.g.
+// "123456789012": {"euc1-az2": "eu-central-1a"}. Needed when AZs are given as
+// AZ IDs, and to check that spokes in other accounts have a firewall endpoint
+// in the same physical AZ. Look it up with
+// `aws ec2 describe-availability-zones --query 'AvailabilityZones[].[ZoneId,ZoneName]'`.
+var AzIdToName = map[string]map[string]string{}
+
+// Route traffic to the firewall endpoints with plain CloudFormation routes
+// instead of the route custom resource Lambda.
+var UseNativeFirewallRoutes = false
+
+// Minutes the route custom resources wait for the firewall endpoints in
+// every AZ to become READY before failing the deployment.
+var FirewallEndpointReadinessTimeoutMinutes = 30.0
+
+// Accounts, OUs or organization ARNs the firewall rule groups and policy are
+// shared with via AWS RAM, e.g. "123456789012" or
+// "arn:aws:organizations::123456789012:ou/o-abc/ou-abc-12345678".
+var FirewallRulesSharePrincipals = []string{}
+
+var FirewallRulesStackName = "FirewallRules"
+
+// Pushes to this branch only redeploy the firewall rules stack through the
+// fast-path pipeline, which synthesizes nothing but the rules stage. Branch
+// off main, and merge the change back to main right after the fast-path
+// deployment, otherwise the next run of the main pipeline reverts it. Rules
+// changed on main in the meantime are reverted by the fast path too.
+var FirewallRulesBranch = "firewall-rules"
+
+type FirewallPolicyVersion struct {
+	name string
+	// Rule group names, in priority order for stateless rule groups.
+	statelessRuleGroups []string
+	statefulRuleGroups  []string
+}
+
+// Firewall policy versions kept side by side. To roll out a larger policy
+// change, add a new version, deploy it, then point
+// ActiveFirewallPolicyVersion at it. Remove the old version once the switch
+// has passed its health checks.
+var FirewallPolicyVersions = []FirewallPolicyVersion{
+	{
+		name:                "blue",
+		statelessRuleGroups: []string{"AllowStateless"},
+		statefulRuleGroups:  []string{"AllowRules", "DenyAll"},
+	},
+}
+
+var ActiveFirewallPolicyVersion = "blue"
+
+type DomainList struct {
+	name string
+	// Domains, with a leading "." to include the subdomains, e.g.
+	// ".example.com".
+	domains []string
+	// Network Firewall egress filtering, "ALLOWLIST" or "DENYLIST". The rule
+	// group is named Domains-<name> and can be referenced from
+	// FirewallPolicyVersions. Empty to skip.
+	egressRulesType string
+	// Route 53 Resolver DNS Firewall action for the spokes, "ALLOW", "BLOCK"
+	// or "ALERT". Empty to skip.
+	dnsAction string
+	// Response to blocked queries, "NODATA" (default), "NXDOMAIN" or
+	// "OVERRIDE" with a CNAME to dnsBlockOverrideDomain.
+	dnsBlockResponse       string
+	dnsBlockOverrideDomain string
+}
+
+// Domain lists used for egress filtering on the firewall and for the DNS
+// Firewall associated with the spoke VPCs, e.g.
+// {name: "blocked", domains: []string{".example.com"}, egressRulesType: "DENYLIST", dnsAction: "BLOCK"}.
+var EgressDomainLists = []DomainList{}
+
+var DnsFirewallRuleGroupExportName = "DnsFirewallRuleGroupId"
+
+type FirewallHealthCheckConfig struct {
+	// How long to watch the alarms after switching policy before the
+	// deployment is considered healthy.
+	monitoringMinutes float64
+	// Thresholds, per minute, for the firewall alert and drop rates.
+	maxAlertsPerMinute  float64
+	maxDroppedPerMinute float64
+	// Names of existing CloudWatch alarms (e.g. synthetic probes) that must
+	// stay out of ALARM after the switch.
+	probeAlarmNames []string
+}
+
+var FirewallHealthCheck = FirewallHealthCheckConfig{
+	monitoringMinutes:   10,
+	maxAlertsPerMinute:  100,
+	maxDroppedPerMinute: 1000,
+	probeAlarmNames:     []string{},
+}
+
+// A transit gateway segment. Attachments whose routeTable tag matches the
+// segment name are associated with the segment's route table. Attachments
+// with any other tag go to the quarantine route table.
+type TgwSegment struct {
+	name string
+	// Summary CIDRs of the segment. Needed for segments that are inspected
+	// or isolated in TgwConnectivityMatrix.
+	cidrs []string
+	// Segments whose route tables learn the routes of this segment's
+	// attachments.
+	propagateTo  []string
+	staticRoutes []TgwStaticRoute
+}
+
+// A static route in the route table of a segment.
+type TgwStaticRoute struct {
+	destinationCidr string
+	// Segment whose attachment the route points at. Leave empty for a
+	// blackhole route.
+	targetSegment string
+}
+
+// The workload and inspection segments are required. The default route of
+// the workload segment towards the inspection VPC is created by the
+// inspection stack. Further segments need CIDRs from the address plan to be
+// inspected or isolated, e.g.
+//
+//	{name: "prod", cidrs: []string{"10.16.0.0/12"}},
+//	{name: "non-prod", cidrs: []string{"10.32.0.0/12"}},
+//	{name: "shared-services", cidrs: []string{"10.48.0.0/16"}},
+//	{name: "on-prem", cidrs: []string{"10.250.0.0/16"}},
+//
+// SharedServices needs the shared-services segment and VpnConnections the
+// on-prem segment, unless they name another one.
+var TgwSegments = []TgwSegment{
+	{
+		name:  "workload",
+		cidrs: []string{"10.110.0.0/15"},
+		// Return traffic from the firewall finds its way back to the
+		// workload VPCs.
+		propagateTo: []string{"inspection"},
+	},
+	{
+		name: "inspection",
+	},
+}
+
+// Isolates the spokes: organization traffic of the workload segment is
+// routed through the inspection VPC and the CIDRs of segments it is not
+// connected to are blackholed. Synth fails if the segment config lets the
+// attachments of any segment other than inspection reach each other, or
+// another segment, without traversing the firewall, so direct connectivity
+// cannot be used with it.
+var SpokeIsolation = false
+
+type TgwConnectivity string
+
+const (
+	// The segments learn each other's routes.
+	TgwDirect TgwConnectivity = "direct"
+	// Traffic between the segments is routed through the inspection VPC.
+	TgwInspected TgwConnectivity = "inspected"
+	// Traffic between the segments is dropped by blackhole routes.
+	TgwIsolated TgwConnectivity = "isolated"
+)
+
+type TgwSegmentConnection struct {
+	segments     [2]string
+	connectivity TgwConnectivity
+}
+
+// How segments may talk to each other. Propagations and static routes are
+// generated from it on top of those in TgwSegments. A segment connected to
+// itself lets its attachments talk to each other. For the example segments
+// of TgwSegments, e.g.
+//
+//	{segments: [2]string{"prod", "shared-services"}, connectivity: TgwDirect},
+//	{segments: [2]string{"non-prod", "shared-services"}, connectivity: TgwDirect},
+//	{segments: [2]string{"prod", "on-prem"}, connectivity: TgwInspected},
+//	{segments: [2]string{"non-prod", "on-prem"}, connectivity: TgwInspected},
+//	{segments: [2]string{"prod", "non-prod"}, connectivity: TgwIsolated},
+//	{segments: [2]string{"workload", "on-prem"}, connectivity: TgwInspected},
+//	{segments: [2]string{"workload", "shared-services"}, connectivity: TgwInspected},
+//	{segments: [2]string{"shared-services", "on-prem"}, connectivity: TgwInspected},
+var TgwConnectivityMatrix = []TgwSegmentConnection{}
+
+// A Site-to-Site VPN connection to the transit gateway.
+type VpnConnectionConfig struct {
+	name string
+	// Public IP and BGP ASN of the customer gateway device.
+	customerGatewayIp string
+	bgpAsn            float64
+	// Static routing instead of BGP. The on-premises CIDRs are then routed
+	// to the VPN attachment with static routes.
+	staticRoutesOnly bool
+	staticRoutes     []string
+	// Segment the VPN attachment is associated with, "on-prem" by default.
+	segment string
+}
+
+// VPN connections to on-premises sites, e.g.
+// {name: "dc1", customerGatewayIp: "203.0.113.10", bgpAsn: 65010}.
+var VpnConnections = []VpnConnectionConfig{}
+
+type DirectConnectConfig struct {
+	// ID of an existing Direct Connect gateway.
+	gatewayId string
+	// Prefixes advertised to on-premises over Direct Connect. Defaults to
+	// the organization CIDR.
+	allowedPrefixes []string
+}
+
+// Direct Connect gateway to associate with the hub transit gateway, e.g.
+// &DirectConnectConfig{gatewayId: "5f2b9a1c-0000-0000-0000-000000000000"}.
+// Its attachment gets a dedicated route table in the DirectConnectSegment
+// segment.
+var DirectConnect *DirectConnectConfig
+
+var DirectConnectSegment = "direct-connect"
+
+type TransitGatewayOptions struct {
+	// Private ASN of the Amazon side of BGP sessions. Zero keeps the
+	// default of 64512.
+	amazonSideAsn               float64
+	dnsSupport                  bool
+	vpnEcmpSupport              bool
+	multicastSupport            bool
+	autoAcceptSharedAttachments bool
+	// CIDR blocks of the transit gateway, e.g. "192.0.2.0/24". Connect peers
+	// take their transit gateway GRE address from them.
+	cidrBlocks []string
+}
+
+var TgwOptions = TransitGatewayOptions{
+	dnsSupport:     true,
+	vpnEcmpSupport: true,
+}
+
+// Prefix for the names of the transit gateway and its route tables, e.g.
+// "hub-eu-central-1-transit-gateway".
+type ResourceNaming struct {
+	environment string
+}
+
+var Naming = ResourceNaming{environment: "hub"}
+
+// A GRE/BGP peer of an SD-WAN appliance on the Connect attachment.
+type ConnectPeerConfig struct {
+	name string
+	// GRE address of the appliance, in the transport VPC.
+	peerAddress string
+	// /29 from 169.254.0.0/16 for the BGP session inside the tunnel.
+	insideCidrBlocks []string
+	peerAsn          float64
+	// GRE address on the transit gateway side. Picked from the cidrBlocks
+	// of TgwOptions when empty.
+	transitGatewayAddress string
+}
+
+type SdWanConfig struct {
+	// CIDR of the transport VPC the SD-WAN appliances run in.
+	transportVpcCidr string
+	peers            []ConnectPeerConfig
+}
+
+// SD-WAN appliances peering with the hub transit gateway through a Connect
+// attachment, e.g. &SdWanConfig{transportVpcCidr: "10.120.0.0/24", peers:
+// []ConnectPeerConfig{{name: "sdwan1", peerAddress: "10.120.0.10",
+// insideCidrBlocks: []string{"169.254.100.0/29"}, peerAsn: 65020}}}.
+// The Connect attachment gets a dedicated route table in the SdWanSegment
+// segment.
+var SdWan *SdWanConfig
+
+var SdWanSegment = "sd-wan"
+
+type TgwFlowLogConfig struct {
+	enabled bool
+	// "cloud-watch-logs" for Logs Insights queries or "s3" for Athena.
+	destinationType string
+}
+
+// Transit gateway flow logs, off by default.
+var TgwFlowLogs = TgwFlowLogConfig{
+	enabled:         false,
+	destinationType: "cloud-watch-logs",
+}
+
+type SharedServicesConfig struct {
+	// CIDR of the shared-services VPC, inside the shared-services segment.
+	cidr string
+	// Interface endpoint services, e.g. "ssm" or "ecr.api". A private hosted
+	// zone for the private DNS name of the service, e.g.
+	// api.ecr.<region>.amazonaws.com, points at each endpoint. Services
+	// missing from endpointPrivateDnsNames fail the synth.
+	endpointServices []string
+}
+
+// Shared-services VPC hosting the interface endpoints of all spokes, e.g.
+// &SharedServicesConfig{cidr: "10.48.0.0/22", endpointServices:
+// []string{"ssm", "ssmmessages", "ec2messages"}}. Spokes then skip their own
+// endpoints. The firewall rules must allow HTTPS from the spokes to it.
+var SharedServices *SharedServicesConfig
+
+type ForwardingRule struct {
+	// Domain forwarded to the target DNS servers, e.g. "corp.example.com".
+	domainName string
+	// DNS servers, "ip" or "ip:port".
+	targetIps []string
+}
+
+type HybridDnsConfig struct {
+	// Domains resolved by the on-premises DNS servers through the outbound
+	// endpoint.
+	forwardingRules []ForwardingRule
+	// Accounts, OUs or organization ARNs the forwarding rules are shared with
+	// via AWS RAM, to associate them with spoke VPCs in those accounts.
+	sharePrincipals []string
+}
+
+// Route 53 Resolver inbound and outbound endpoints in the shared-services
+// VPC, requires SharedServices. On-premises DNS servers forward the AWS
+// private zones to the inbound endpoint IPs output by the SharedServices
+// stack.
+var HybridDns *HybridDnsConfig
+
+// SSM parameter the segment mapping is published to for the attachment
+// handler.
+var TgwSegmentsParameterName = "/hub-and-spoke/tgw-segments"
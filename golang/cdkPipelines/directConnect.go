@@ -0,0 +1,115 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cdkPipelines
+
+import (
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	ec2 "github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
+	iam "github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	lambda "github.com/aws/aws-cdk-go/awscdk/v2/awslambda"
+	logs "github.com/aws/aws-cdk-go/awscdk/v2/awslogs"
+	cr "github.com/aws/aws-cdk-go/awscdk/v2/customresources"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// Associates an existing Direct Connect gateway with the transit gateway and
+// wires its attachment into the route tables of the Direct Connect segment.
+// CloudFormation has no resource for the association, so a custom resource
+// creates it and returns the attachment once it is available.
+func createDirectConnectAssociation(stack awscdk.Stack, transitGWId *string, config DirectConnectConfig, routeTables map[string]*string) {
+	allowedPrefixes := config.allowedPrefixes
+	if len(allowedPrefixes) == 0 {
+		allowedPrefixes = []string{OrganizationCidr}
+	}
+
+	associationRole := iam.NewRole(stack, jsii.String("dxAssociationLambdaRole"), &iam.RoleProps{
+		AssumedBy: iam.NewServicePrincipal(jsii.String("lambda.amazonaws.com"), nil),
+		Path:      jsii.String("/"),
+		ManagedPolicies: &[]iam.IManagedPolicy{
+			iam.ManagedPolicy_FromAwsManagedPolicyName(jsii.String("service-role/AWSLambdaBasicExecutionRole")),
+		},
+	})
+
+	associationRole.AddToPolicy(
+		iam.NewPolicyStatement(&iam.PolicyStatementProps{
+			Actions: jsii.Strings(
+				"directconnect:CreateDirectConnectGatewayAssociation",
+				"directconnect:UpdateDirectConnectGatewayAssociation",
+				"directconnect:DeleteDirectConnectGatewayAssociation",
+				"directconnect:DescribeDirectConnectGatewayAssociations",
+				"ec2:DescribeTransitGatewayAttachments",
+			),
+			Effect: iam.Effect_ALLOW,
+			Resources: &[]*string{
+				jsii.String("*"),
+			},
+		}),
+	)
+
+	onEventLambda := lambda.NewFunction(stack, jsii.String("DxAssociationFunction"), &lambda.FunctionProps{
+		Runtime:      goLambdaRuntime(),
+		Architecture: goLambdaArchitecture(),
+		Handler:      jsii.String("onEvent"),
+		Role:         associationRole,
+		Timeout:      awscdk.Duration_Seconds(jsii.Number(60)),
+		Code:         goLambdaCode("./lambda/dxassociation"),
+	})
+
+	isCompleteLambda := lambda.NewFunction(stack, jsii.String("DxAssociationCheckFunction"), &lambda.FunctionProps{
+		Runtime:      goLambdaRuntime(),
+		Architecture: goLambdaArchitecture(),
+		Handler:      jsii.String("isComplete"),
+		Role:         associationRole,
+		Timeout:      awscdk.Duration_Seconds(jsii.Number(60)),
+		Code:         goLambdaCode("./lambda/dxassociation"),
+	})
+
+	// Associations usually take several minutes.
+	provider := cr.NewProvider(stack, jsii.String("dxAssociationProvider"), &cr.ProviderProps{
+		OnEventHandler:    onEventLambda,
+		IsCompleteHandler: isCompleteLambda,
+		QueryInterval:     awscdk.Duration_Seconds(jsii.Number(30)),
+		TotalTimeout:      awscdk.Duration_Minutes(jsii.Number(60)),
+		LogRetention:      logs.RetentionDays_ONE_DAY,
+	})
+
+	association := awscdk.NewCustomResource(stack, jsii.String("DxGatewayAssociation"), &awscdk.CustomResourceProps{
+		ServiceToken: provider.ServiceToken(),
+		Properties: &map[string]interface{}{
+			"DirectConnectGatewayId": config.gatewayId,
+			"TransitGatewayId":       transitGWId,
+			"AllowedPrefixes":        allowedPrefixes,
+		},
+	})
+	attachmentId := association.GetAttString(jsii.String("TransitGatewayAttachmentId"))
+
+	ec2.NewCfnTransitGatewayRouteTableAssociation(stack, jsii.String("DxAssociation"), &ec2.CfnTransitGatewayRouteTableAssociationProps{
+		TransitGatewayAttachmentId: attachmentId,
+		TransitGatewayRouteTableId: routeTables[DirectConnectSegment],
+	})
+
+	for _, target := range resolvedSegment(DirectConnectSegment).propagateTo {
+		ec2.NewCfnTransitGatewayRouteTablePropagation(stack, jsii.String("DxPropagation-"+target), &ec2.CfnTransitGatewayRouteTablePropagationProps{
+			TransitGatewayAttachmentId: attachmentId,
+			TransitGatewayRouteTableId: routeTables[target],
+		})
+	}
+
+	awscdk.NewCfnOutput(stack, jsii.String("dx-attachment-output"), &awscdk.CfnOutputProps{
+		Value:       attachmentId,
+		Description: jsii.String("Transit gateway attachment of the Direct Connect gateway"),
+	})
+}
@@ -0,0 +1,156 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cdkPipelines
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	firewall "github.com/aws/aws-cdk-go/awscdk/v2/awsnetworkfirewall"
+	resolver "github.com/aws/aws-cdk-go/awscdk/v2/awsroute53resolver"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// Network Firewall domain list rule groups for the lists with an
+// egressRulesType, by rule group name.
+func createDomainListRuleGroups(stack awscdk.Stack, lists []DomainList) map[string]*string {
+	ruleGroupArns := map[string]*string{}
+	for _, list := range lists {
+		if list.egressRulesType == "" {
+			continue
+		}
+		if list.egressRulesType != "ALLOWLIST" && list.egressRulesType != "DENYLIST" {
+			panic(fmt.Sprintf("domain list %q: egressRulesType must be ALLOWLIST or DENYLIST, got %q", list.name, list.egressRulesType))
+		}
+
+		name := fmt.Sprintf("Domains-%s", list.name)
+		ruleGroup := firewall.NewCfnRuleGroup(stack, jsii.String(name), &firewall.CfnRuleGroupProps{
+			// The capacity cannot be changed later, leave room for the list to grow.
+			Capacity:      jsii.Number(float64(max(100, 4*len(list.domains)))),
+			RuleGroupName: jsii.String(name),
+			Type:          jsii.String("STATEFUL"),
+			Description:   jsii.String(fmt.Sprintf("Egress %s %s", strings.ToLower(list.egressRulesType), list.name)),
+			RuleGroup: firewall.CfnRuleGroup_RuleGroupProperty{
+				// Domain lists only inspect HOME_NET, which defaults to the
+				// inspection VPC.
+				RuleVariables: &firewall.CfnRuleGroup_RuleVariablesProperty{
+					IpSets: map[string]interface{}{
+						"HOME_NET": &firewall.CfnRuleGroup_IPSetProperty{
+							Definition: jsii.Strings(homeNet()...),
+						},
+					},
+				},
+				RulesSource: firewall.CfnRuleGroup_RulesSourceProperty{
+					RulesSourceList: &firewall.CfnRuleGroup_RulesSourceListProperty{
+						GeneratedRulesType: jsii.String(list.egressRulesType),
+						Targets:            jsii.Strings(list.domains...),
+						TargetTypes:        jsii.Strings("TLS_SNI", "HTTP_HOST"),
+					},
+				},
+			},
+		})
+		ruleGroupArns[name] = ruleGroup.AttrRuleGroupArn()
+	}
+	return ruleGroupArns
+}
+
+// DNS Firewall rule group for the lists with a dnsAction, or nil when there
+// is none. Its ID is exported so the spoke stacks can associate it.
+func createDnsFirewallRuleGroup(stack awscdk.Stack, lists []DomainList) resolver.CfnFirewallRuleGroup {
+	var rules []interface{}
+	for i, list := range lists {
+		if list.dnsAction == "" {
+			continue
+		}
+
+		domainList := resolver.NewCfnFirewallDomainList(stack, jsii.String(fmt.Sprintf("DnsDomainList-%s", list.name)), &resolver.CfnFirewallDomainListProps{
+			Name:    jsii.String(list.name),
+			Domains: jsii.Strings(dnsFirewallDomains(list.domains)...),
+		})
+
+		rule := &resolver.CfnFirewallRuleGroup_FirewallRuleProperty{
+			Action:               jsii.String(list.dnsAction),
+			FirewallDomainListId: domainList.AttrId(),
+			Priority:             jsii.Number(float64(100 * (i + 1))),
+		}
+		switch list.dnsAction {
+		case "ALLOW", "ALERT":
+		case "BLOCK":
+			rule.BlockResponse = jsii.String("NODATA")
+			if list.dnsBlockResponse != "" {
+				rule.BlockResponse = jsii.String(list.dnsBlockResponse)
+			}
+			if *rule.BlockResponse == "OVERRIDE" {
+				if list.dnsBlockOverrideDomain == "" {
+					panic(fmt.Sprintf("domain list %q: dnsBlockOverrideDomain is required for the OVERRIDE response", list.name))
+				}
+				rule.BlockOverrideDnsType = jsii.String("CNAME")
+				rule.BlockOverrideDomain = jsii.String(list.dnsBlockOverrideDomain)
+				rule.BlockOverrideTtl = jsii.Number(60)
+			}
+		default:
+			panic(fmt.Sprintf("domain list %q: dnsAction must be ALLOW, BLOCK or ALERT, got %q", list.name, list.dnsAction))
+		}
+		rules = append(rules, rule)
+	}
+
+	if len(rules) == 0 {
+		return nil
+	}
+
+	ruleGroup := resolver.NewCfnFirewallRuleGroup(stack, jsii.String("DnsFirewallRuleGroup"), &resolver.CfnFirewallRuleGroupProps{
+		Name:          jsii.String("SpokeDnsFirewall"),
+		FirewallRules: rules,
+	})
+
+	awscdk.NewCfnOutput(stack, jsii.String("dns-firewall-rule-group-output"), &awscdk.CfnOutputProps{
+		Value:      ruleGroup.AttrId(),
+		ExportName: jsii.String(DnsFirewallRuleGroupExportName),
+	})
+
+	return ruleGroup
+}
+
+func homeNet() []string {
+	if DualStack != nil && DualStack.organizationCidr != "" {
+		return []string{OrganizationCidr, DualStack.organizationCidr}
+	}
+	return []string{OrganizationCidr}
+}
+
+func dnsFirewallEnabled(lists []DomainList) bool {
+	for _, list := range lists {
+		if list.dnsAction != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Network Firewall matches the subdomains of ".example.com" as well as the
+// domain itself, DNS Firewall needs both "example.com" and "*.example.com".
+func dnsFirewallDomains(domains []string) []string {
+	var dnsDomains []string
+	for _, domain := range domains {
+		if strings.HasPrefix(domain, ".") {
+			dnsDomains = append(dnsDomains, domain[1:], "*"+domain)
+		} else {
+			dnsDomains = append(dnsDomains, domain)
+		}
+	}
+	return dnsDomains
+}
@@ -0,0 +1,81 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cdkPipelines
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	ec2 "github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
+	nf "github.com/aws/aws-cdk-go/awscdk/v2/awsnetworkfirewall"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// Returns the ID of the firewall endpoint in the given AZ. EndpointIds is a
+// list of "<az>:<endpoint id>" entries. When the AZ name is known at synth
+// time the list is joined and split on "<az>:", so the result does not depend
+// on the order of the list. CloudFormation only accepts literal delimiters in
+// Fn::Split, so AZs that are tokens fall back to the position of the AZ in
+// the VPC, which relies on the endpoints being listed in AZ order.
+func firewallEndpointId(networkFw nf.CfnFirewall, az *string, index int) *string {
+	if !*awscdk.Token_IsUnresolved(az) {
+		joined := awscdk.Fn_Join(jsii.String(","), networkFw.AttrEndpointIds())
+		afterAz := awscdk.Fn_Select(jsii.Number(1), awscdk.Fn_Split(jsii.String(*az+":"), joined, nil))
+		return awscdk.Fn_Select(jsii.Number(0), awscdk.Fn_Split(jsii.String(","), afterAz, nil))
+	}
+
+	entry := awscdk.Fn_Select(jsii.Number(float64(index)), networkFw.AttrEndpointIds())
+	return awscdk.Fn_Select(jsii.Number(1), awscdk.Fn_Split(jsii.String(":"), entry, nil))
+}
+
+func createNativeFirewallRoutes(stack awscdk.Stack, vpc ec2.Vpc, networkFw nf.CfnFirewall, orgCidr string, ipv6 bool) {
+	azIndex := map[string]int{}
+	for i, az := range *vpc.AvailabilityZones() {
+		azIndex[*az] = i
+	}
+
+	tgwSubs := vpc.SelectSubnetObjects(&ec2.SubnetSelection{
+		SubnetGroupName: jsii.String("Tgw_Subnet"),
+	})
+
+	for _, subnet := range *tgwSubs {
+		ec2.NewCfnRoute(stack, jsii.String(fmt.Sprintf("FirewallEndpointRoute-%s", strings.SplitN(*subnet.Node().Path(), "/", 1))), &ec2.CfnRouteProps{
+			RouteTableId:         subnet.RouteTable().RouteTableId(),
+			DestinationCidrBlock: jsii.String("0.0.0.0/0"),
+			VpcEndpointId:        firewallEndpointId(networkFw, subnet.AvailabilityZone(), azIndex[*subnet.AvailabilityZone()]),
+		})
+		if ipv6 {
+			ec2.NewCfnRoute(stack, jsii.String(fmt.Sprintf("FirewallEndpointRouteIpv6-%s", strings.SplitN(*subnet.Node().Path(), "/", 1))), &ec2.CfnRouteProps{
+				RouteTableId:             subnet.RouteTable().RouteTableId(),
+				DestinationIpv6CidrBlock: jsii.String("::/0"),
+				VpcEndpointId:            firewallEndpointId(networkFw, subnet.AvailabilityZone(), azIndex[*subnet.AvailabilityZone()]),
+			})
+		}
+	}
+
+	pubSubs := vpc.SelectSubnetObjects(&ec2.SubnetSelection{
+		SubnetGroupName: jsii.String("Public"),
+	})
+
+	for _, subnet := range *pubSubs {
+		ec2.NewCfnRoute(stack, jsii.String(fmt.Sprintf("ReturnEndpointRoute-%s", strings.SplitN(*subnet.Node().Path(), "/", 1))), &ec2.CfnRouteProps{
+			RouteTableId:         subnet.RouteTable().RouteTableId(),
+			DestinationCidrBlock: &orgCidr,
+			VpcEndpointId:        firewallEndpointId(networkFw, subnet.AvailabilityZone(), azIndex[*subnet.AvailabilityZone()]),
+		})
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cdkPipelines
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	cloudwatch "github.com/aws/aws-cdk-go/awscdk/v2/awscloudwatch"
+	iam "github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	lambda "github.com/aws/aws-cdk-go/awscdk/v2/awslambda"
+	logs "github.com/aws/aws-cdk-go/awscdk/v2/awslogs"
+	nf "github.com/aws/aws-cdk-go/awscdk/v2/awsnetworkfirewall"
+	cr "github.com/aws/aws-cdk-go/awscdk/v2/customresources"
+	"github.com/aws/constructs-go/constructs/v10"
+	"github.com/aws/jsii-runtime-go"
+)
+
+type PolicySwitchHealthCheckProps struct {
+	firewall          nf.CfnFirewall
+	firewallName      *string
+	firewallPolicyArn *string
+	alertLogGroup     logs.ILogGroup
+	availabilityZones *[]*string
+	config            FirewallHealthCheckConfig
+}
+
+// Watches the firewall after its policy has been switched. The custom
+// resource only completes once the monitoring window has passed without any
+// of the health check alarms going into ALARM. Otherwise it fails, and the
+// CloudFormation rollback switches the firewall back to the previous policy.
+func CreatePolicySwitchHealthCheck(scope constructs.Construct, props *PolicySwitchHealthCheckProps) {
+	alertsMetric := logs.NewMetricFilter(scope, jsii.String("FWAlertsMetricFilter"), &logs.MetricFilterProps{
+		LogGroup:        props.alertLogGroup,
+		FilterPattern:   logs.FilterPattern_AllEvents(),
+		MetricNamespace: jsii.String("InspectionFirewall"),
+		MetricName:      jsii.String("FirewallAlerts"),
+		MetricValue:     jsii.String("1"),
+		DefaultValue:    jsii.Number(0),
+	}).Metric(&cloudwatch.MetricOptions{
+		Statistic: jsii.String("Sum"),
+		Period:    awscdk.Duration_Minutes(jsii.Number(1)),
+	})
+
+	alertAlarm := cloudwatch.NewAlarm(scope, jsii.String("FWAlertRateAlarm"), &cloudwatch.AlarmProps{
+		AlarmDescription:   jsii.String("Network Firewall alert rate after a policy switch"),
+		Metric:             alertsMetric,
+		Threshold:          jsii.Number(props.config.maxAlertsPerMinute),
+		EvaluationPeriods:  jsii.Number(1),
+		ComparisonOperator: cloudwatch.ComparisonOperator_GREATER_THAN_THRESHOLD,
+		TreatMissingData:   cloudwatch.TreatMissingData_NOT_BREACHING,
+	})
+
+	// Dropped packets are reported per AZ, so sum them across all
+	// firewall endpoints.
+	droppedMetrics := map[string]cloudwatch.IMetric{}
+	expression := ""
+	for i, az := range *props.availabilityZones {
+		key := fmt.Sprintf("az%d", i)
+		droppedMetrics[key] = cloudwatch.NewMetric(&cloudwatch.MetricProps{
+			Namespace:  jsii.String("AWS/NetworkFirewall"),
+			MetricName: jsii.String("DroppedPackets"),
+			DimensionsMap: &map[string]*string{
+				"FirewallName":     props.firewallName,
+				"AvailabilityZone": az,
+				"Engine":           jsii.String("Stateful"),
+			},
+			Statistic: jsii.String("Sum"),
+			Period:    awscdk.Duration_Minutes(jsii.Number(1)),
+		})
+		if expression != "" {
+			expression += "+"
+		}
+		expression += key
+	}
+
+	dropAlarm := cloudwatch.NewAlarm(scope, jsii.String("FWDropRateAlarm"), &cloudwatch.AlarmProps{
+		AlarmDescription: jsii.String("Network Firewall drop rate after a policy switch"),
+		Metric: cloudwatch.NewMathExpression(&cloudwatch.MathExpressionProps{
+			Expression:   jsii.String(expression),
+			UsingMetrics: &droppedMetrics,
+			Label:        jsii.String("DroppedPackets"),
+			Period:       awscdk.Duration_Minutes(jsii.Number(1)),
+		}),
+		Threshold:          jsii.Number(props.config.maxDroppedPerMinute),
+		EvaluationPeriods:  jsii.Number(1),
+		ComparisonOperator: cloudwatch.ComparisonOperator_GREATER_THAN_THRESHOLD,
+		TreatMissingData:   cloudwatch.TreatMissingData_NOT_BREACHING,
+	})
+
+	alarmNames := []*string{alertAlarm.AlarmName(), dropAlarm.AlarmName()}
+	for _, name := range props.config.probeAlarmNames {
+		alarmNames = append(alarmNames, jsii.String(name))
+	}
+
+	healthCheckRole := iam.NewRole(scope, jsii.String("policySwitchLambdaRole"), &iam.RoleProps{
+		AssumedBy: iam.NewServicePrincipal(jsii.String("lambda.amazonaws.com"), nil),
+		Path:      jsii.String("/"),
+		ManagedPolicies: &[]iam.IManagedPolicy{
+			iam.ManagedPolicy_FromAwsManagedPolicyName(jsii.String("service-role/AWSLambdaBasicExecutionRole")),
+		},
+	})
+
+	healthCheckRole.AddToPolicy(
+		iam.NewPolicyStatement(&iam.PolicyStatementProps{
+			Actions: jsii.Strings("cloudwatch:DescribeAlarms"),
+			Effect:  iam.Effect_ALLOW,
+			Resources: &[]*string{
+				jsii.String("*"),
+			},
+		}),
+	)
+	// Tells a rollback, which must not wait for the health check, from a
+	// switch.
+	healthCheckRole.AddToPolicy(
+		iam.NewPolicyStatement(&iam.PolicyStatementProps{
+			Actions: jsii.Strings("cloudformation:DescribeStacks"),
+			Effect:  iam.Effect_ALLOW,
+			Resources: &[]*string{
+				awscdk.Stack_Of(scope).StackId(),
+			},
+		}),
+	)
+
+	onEventLambda := lambda.NewFunction(scope, jsii.String("PolicySwitchFunction"), &lambda.FunctionProps{
+		Runtime:      goLambdaRuntime(),
+		Architecture: goLambdaArchitecture(),
+		Handler:      jsii.String("onEvent"),
+		Role:         healthCheckRole,
+		Timeout:      awscdk.Duration_Seconds(jsii.Number(60)),
+		Code:         goLambdaCode("./lambda/policyswitch"),
+	})
+
+	isCompleteLambda := lambda.NewFunction(scope, jsii.String("PolicySwitchCheckFunction"), &lambda.FunctionProps{
+		Runtime:      goLambdaRuntime(),
+		Architecture: goLambdaArchitecture(),
+		Handler:      jsii.String("isComplete"),
+		Role:         healthCheckRole,
+		Timeout:      awscdk.Duration_Seconds(jsii.Number(60)),
+		Code:         goLambdaCode("./lambda/policyswitch"),
+	})
+
+	provider := cr.NewProvider(scope, jsii.String("policySwitchProvider"), &cr.ProviderProps{
+		OnEventHandler:    onEventLambda,
+		IsCompleteHandler: isCompleteLambda,
+		QueryInterval:     awscdk.Duration_Minutes(jsii.Number(1)),
+		TotalTimeout:      awscdk.Duration_Minutes(jsii.Number(props.config.monitoringMinutes + 10)),
+		LogRetention:      logs.RetentionDays_ONE_DAY,
+	})
+
+	// The policy ARN is a property so the check runs every time the firewall
+	// is switched to another policy version. Updates that keep the policy,
+	// and rollbacks, complete without waiting.
+	healthCheck := awscdk.NewCustomResource(scope, jsii.String("PolicySwitchHealthCheck"), &awscdk.CustomResourceProps{
+		ServiceToken: provider.ServiceToken(),
+		Properties: &map[string]interface{}{
+			"FirewallArn":       props.firewall.AttrFirewallArn(),
+			"FirewallPolicyArn": props.firewallPolicyArn,
+			"AlarmNames":        alarmNames,
+			"MonitoringMinutes": props.config.monitoringMinutes,
+		},
+	})
+	healthCheck.Node().AddDependency(props.firewall)
+}
@@ -16,19 +16,32 @@
 package cdkPipelines
 
 import (
+	"fmt"
+
 	"github.com/aws/aws-cdk-go/awscdk/v2"
 	firewall "github.com/aws/aws-cdk-go/awscdk/v2/awsnetworkfirewall"
+	ram "github.com/aws/aws-cdk-go/awscdk/v2/awsram"
 	"github.com/aws/constructs-go/constructs/v10"
 	"github.com/aws/jsii-runtime-go"
 )
 
 type FirewallRuleStackProps struct {
 	awscdk.StackProps
+	// Account IDs, OU ARNs or organization ARNs the rule groups and the
+	// firewall policy are shared with through AWS RAM. No share is created
+	// when empty.
+	sharePrincipals []string
+	// Allow sharing with principals outside of the AWS Organization.
+	allowExternalPrincipals bool
 }
 
 type FirewallRulesStackOutputs struct {
 	awscdk.Stack
-	fwPolicyArn *string
+	// ARN of the active policy version and of every version by name.
+	fwPolicyArn  *string
+	fwPolicyArns map[string]*string
+	// Nil unless a domain list has a DNS Firewall action.
+	dnsFirewallRuleGroupId *string
 }
 
 func NetworkFirewallRules(scope constructs.Construct, id string, props *FirewallRuleStackProps) FirewallRulesStackOutputs {
@@ -38,7 +51,7 @@ func NetworkFirewallRules(scope constructs.Construct, id string, props *Firewall
 	}
 
 	stack := awscdk.NewStack(scope, &id, &sprops)
-	fwAllowStatelessRuleGroup := firewall.NewCfnRuleGroup(scope, jsii.String("fwAllowStatelessRuleGroup"), &firewall.CfnRuleGroupProps{
+	fwAllowStatelessRuleGroup := firewall.NewCfnRuleGroup(stack, jsii.String("fwAllowStatelessRuleGroup"), &firewall.CfnRuleGroupProps{
 		Capacity:      jsii.Number(10),
 		RuleGroupName: jsii.String("AllowStateless"),
 		Type:          jsii.String("STATELESS"),
@@ -74,14 +87,14 @@ func NetworkFirewallRules(scope constructs.Construct, id string, props *Firewall
 		},
 	})
 
-	fwAllowRuleGroup := firewall.NewCfnRuleGroup(scope, jsii.String("fwAllowRuleGroup"), &firewall.CfnRuleGroupProps{
+	fwAllowRuleGroup := firewall.NewCfnRuleGroup(stack, jsii.String("fwAllowRuleGroup"), &firewall.CfnRuleGroupProps{
 		Capacity:      jsii.Number(10),
 		RuleGroupName: jsii.String("AllowRules"),
 		Type:          jsii.String("STATEFUL"),
 		Description:   jsii.String("Allow traffic to Internet"),
 		RuleGroup: firewall.CfnRuleGroup_RuleGroupProperty{
 			RulesSource: firewall.CfnRuleGroup_RulesSourceProperty{
-				StatefulRules: []interface{}{
+				StatefulRules: append([]interface{}{
 					&firewall.CfnRuleGroup_StatefulRuleProperty{
 						Action: jsii.String("PASS"),
 						Header: &firewall.CfnRuleGroup_HeaderProperty{
@@ -130,12 +143,12 @@ func NetworkFirewallRules(scope constructs.Construct, id string, props *Firewall
 							},
 						},
 					},
-				},
+				}, optionalAllowRules()...),
 			},
 		},
 	})
 
-	fwDenyRuleGroup := firewall.NewCfnRuleGroup(scope, jsii.String("fwDenyRuleGroup"), &firewall.CfnRuleGroupProps{
+	fwDenyRuleGroup := firewall.NewCfnRuleGroup(stack, jsii.String("fwDenyRuleGroup"), &firewall.CfnRuleGroupProps{
 		Capacity:      jsii.Number(10),
 		RuleGroupName: jsii.String("DenyAll"),
 		Type:          jsii.String("STATEFUL"),
@@ -164,31 +177,117 @@ func NetworkFirewallRules(scope constructs.Construct, id string, props *Firewall
 		},
 	})
 
-	fwPolicy := firewall.NewCfnFirewallPolicy(scope, jsii.String("FwPolicy"), &firewall.CfnFirewallPolicyProps{
-		FirewallPolicy: &firewall.CfnFirewallPolicy_FirewallPolicyProperty{
-			StatelessDefaultActions:         jsii.Strings("aws:forward_to_sfe"),
-			StatelessFragmentDefaultActions: jsii.Strings("aws:forward_to_sfe"),
-			StatelessRuleGroupReferences: []interface{}{
-				&firewall.CfnFirewallPolicy_StatelessRuleGroupReferenceProperty{
-					Priority:    jsii.Number(1),
-					ResourceArn: fwAllowStatelessRuleGroup.AttrRuleGroupArn(),
-				},
-			},
-			StatefulRuleGroupReferences: []interface{}{
-				&firewall.CfnFirewallPolicy_StatefulRuleGroupReferenceProperty{
-					ResourceArn: fwAllowRuleGroup.AttrRuleGroupArn(),
-				},
-				&firewall.CfnFirewallPolicy_StatefulRuleGroupReferenceProperty{
-					ResourceArn: fwDenyRuleGroup.AttrRuleGroupArn(),
-				},
-			},
-		},
-		FirewallPolicyName: jsii.String("SamplePolicy"),
-	})
+	ruleGroupArns := map[string]*string{
+		"AllowStateless": fwAllowStatelessRuleGroup.AttrRuleGroupArn(),
+		"AllowRules":     fwAllowRuleGroup.AttrRuleGroupArn(),
+		"DenyAll":        fwDenyRuleGroup.AttrRuleGroupArn(),
+	}
+	domainRuleGroupArns := createDomainListRuleGroups(stack, EgressDomainLists)
+	for name, arn := range domainRuleGroupArns {
+		ruleGroupArns[name] = arn
+	}
+	dnsFirewallRuleGroup := createDnsFirewallRuleGroup(stack, EgressDomainLists)
 
 	var outputs FirewallRulesStackOutputs
 	outputs.Stack = stack
-	outputs.fwPolicyArn = fwPolicy.AttrFirewallPolicyArn()
+	outputs.fwPolicyArns = map[string]*string{}
+	if dnsFirewallRuleGroup != nil {
+		outputs.dnsFirewallRuleGroupId = dnsFirewallRuleGroup.AttrId()
+	}
+
+	shareArns := []*string{
+		fwAllowStatelessRuleGroup.AttrRuleGroupArn(),
+		fwAllowRuleGroup.AttrRuleGroupArn(),
+		fwDenyRuleGroup.AttrRuleGroupArn(),
+	}
+	for _, list := range EgressDomainLists {
+		if arn, ok := domainRuleGroupArns[fmt.Sprintf("Domains-%s", list.name)]; ok {
+			shareArns = append(shareArns, arn)
+		}
+	}
+	if dnsFirewallRuleGroup != nil {
+		shareArns = append(shareArns, dnsFirewallRuleGroup.AttrArn())
+	}
+
+	// One policy per version so a new version can be created alongside the
+	// one the firewall is using and switched to (and back) without updating
+	// the active policy in place.
+	for _, version := range FirewallPolicyVersions {
+		var statelessRefs []interface{}
+		for i, name := range version.statelessRuleGroups {
+			statelessRefs = append(statelessRefs, &firewall.CfnFirewallPolicy_StatelessRuleGroupReferenceProperty{
+				Priority:    jsii.Number(float64(i + 1)),
+				ResourceArn: lookupRuleGroupArn(ruleGroupArns, name),
+			})
+		}
+
+		var statefulRefs []interface{}
+		for _, name := range version.statefulRuleGroups {
+			statefulRefs = append(statefulRefs, &firewall.CfnFirewallPolicy_StatefulRuleGroupReferenceProperty{
+				ResourceArn: lookupRuleGroupArn(ruleGroupArns, name),
+			})
+		}
+
+		fwPolicy := firewall.NewCfnFirewallPolicy(stack, jsii.String(fmt.Sprintf("FwPolicy-%s", version.name)), &firewall.CfnFirewallPolicyProps{
+			FirewallPolicy: &firewall.CfnFirewallPolicy_FirewallPolicyProperty{
+				StatelessDefaultActions:         jsii.Strings("aws:forward_to_sfe"),
+				StatelessFragmentDefaultActions: jsii.Strings("aws:forward_to_sfe"),
+				StatelessRuleGroupReferences:    statelessRefs,
+				StatefulRuleGroupReferences:     statefulRefs,
+			},
+			FirewallPolicyName: jsii.String(fmt.Sprintf("SamplePolicy-%s", version.name)),
+		})
+
+		// Exported so the Inspection stack can reference the policy without a
+		// hard dependency on this stack being part of the same deployment.
+		// CloudFormation refuses to remove an export that is still imported,
+		// so the version in use cannot be deleted by accident.
+		awscdk.NewCfnOutput(stack, jsii.String(fmt.Sprintf("fw-policy-arn-output-%s", version.name)), &awscdk.CfnOutputProps{
+			Value:      fwPolicy.AttrFirewallPolicyArn(),
+			ExportName: jsii.String(FirewallPolicyExportName(version.name)),
+		})
+
+		outputs.fwPolicyArns[version.name] = fwPolicy.AttrFirewallPolicyArn()
+		if version.name == ActiveFirewallPolicyVersion {
+			outputs.fwPolicyArn = fwPolicy.AttrFirewallPolicyArn()
+		}
+		shareArns = append(shareArns, fwPolicy.AttrFirewallPolicyArn())
+	}
+
+	if outputs.fwPolicyArn == nil {
+		panic(fmt.Sprintf("active firewall policy version %q is not defined in FirewallPolicyVersions", ActiveFirewallPolicyVersion))
+	}
+
+	// Share the centrally managed rule groups and policy so other regional
+	// hubs or business unit firewalls can reference them instead of
+	// duplicating the rules.
+	if props != nil && len(props.sharePrincipals) > 0 {
+		ram.NewCfnResourceShare(stack, jsii.String("FwRulesResourceShare"), &ram.CfnResourceShareProps{
+			Name:                    jsii.String("NetworkFirewallRules"),
+			AllowExternalPrincipals: jsii.Bool(props.allowExternalPrincipals),
+			Principals:              jsii.Strings(props.sharePrincipals...),
+			ResourceArns:            &shareArns,
+		})
+	}
 
 	return outputs
 }
+
+// Export name of the ARN of the given firewall policy version.
+func FirewallPolicyExportName(version string) string {
+	return fmt.Sprintf("FirewallPolicyArn-%s", version)
+}
+
+// Allow rules that depend on the topology. Their SIDs are fixed so enabling
+// one does not renumber the others.
+func optionalAllowRules() []interface{} {
+	return append(hybridDnsAllowRules(4), ipv6AllowRules(6)...)
+}
+
+func lookupRuleGroupArn(ruleGroupArns map[string]*string, name string) *string {
+	arn, ok := ruleGroupArns[name]
+	if !ok {
+		panic(fmt.Sprintf("firewall policy references unknown rule group %q", name))
+	}
+	return arn
+}
@@ -0,0 +1,84 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cdkPipelines
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	lambda "github.com/aws/aws-cdk-go/awscdk/v2/awslambda"
+	s3assets "github.com/aws/aws-cdk-go/awscdk/v2/awss3assets"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// The Lambda handlers are packages of this module, built into a bootstrap
+// binary for the OS-only runtime.
+func goLambdaRuntime() lambda.Runtime {
+	return lambda.NewRuntime(jsii.String("provided.al2023"), lambda.RuntimeFamily_OTHER, nil)
+}
+
+func goLambdaArchitecture() lambda.Architecture {
+	return lambda.Architecture_ARM_64()
+}
+
+// Builds the handler package (e.g. "./lambda/routes") with the local Go
+// toolchain, falling back to a Go container when it is not installed. The
+// asset hash is taken from the binary, so only handler changes update the
+// functions.
+func goLambdaCode(pkg string) lambda.Code {
+	buildFlags := fmt.Sprintf("-trimpath -buildvcs=false -ldflags='-s -w' -tags lambda.norpc -o /asset-output/bootstrap %s", pkg)
+
+	return lambda.Code_FromAsset(jsii.String("."), &s3assets.AssetOptions{
+		AssetHashType: awscdk.AssetHashType_OUTPUT,
+		Exclude:       jsii.Strings("cdk.out", ".git"),
+		Bundling: &awscdk.BundlingOptions{
+			Image:   awscdk.DockerImage_FromRegistry(jsii.String("public.ecr.aws/docker/library/golang:1.24")),
+			Command: jsii.Strings("bash", "-c", "go build "+buildFlags),
+			Environment: &map[string]*string{
+				"GOOS":        jsii.String("linux"),
+				"GOARCH":      jsii.String("arm64"),
+				"CGO_ENABLED": jsii.String("0"),
+				"GOCACHE":     jsii.String("/tmp/go-cache"),
+				"GOPATH":      jsii.String("/tmp/go"),
+			},
+			Local: &goLocalBundling{pkg: pkg},
+		},
+	})
+}
+
+type goLocalBundling struct {
+	pkg string
+}
+
+func (b *goLocalBundling) TryBundle(outputDir *string, options *awscdk.BundlingOptions) *bool {
+	if _, err := exec.LookPath("go"); err != nil {
+		return jsii.Bool(false)
+	}
+
+	cmd := exec.Command("go", "build", "-trimpath", "-buildvcs=false", "-ldflags=-s -w", "-tags", "lambda.norpc",
+		"-o", filepath.Join(*outputDir, "bootstrap"), b.pkg)
+	cmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH=arm64", "CGO_ENABLED=0")
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		panic(fmt.Sprintf("building Lambda handler %s: %v", b.pkg, err))
+	}
+
+	return jsii.Bool(true)
+}
@@ -0,0 +1,199 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cdkPipelines
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	ec2 "github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
+	firewall "github.com/aws/aws-cdk-go/awscdk/v2/awsnetworkfirewall"
+	ram "github.com/aws/aws-cdk-go/awscdk/v2/awsram"
+	resolver "github.com/aws/aws-cdk-go/awscdk/v2/awsroute53resolver"
+	cr "github.com/aws/aws-cdk-go/awscdk/v2/customresources"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// Inbound and outbound Resolver endpoints in the shared-services VPC, the
+// forwarding rules, their association with the shared-services and spoke
+// VPCs and their RAM share.
+func createHybridDns(stack awscdk.Stack, vpc ec2.Vpc, subnetIds []*string, config HybridDnsConfig, spokeVpcIds []*string) {
+	securityGroup := ec2.NewSecurityGroup(stack, jsii.String("ResolverEndpointSG"), &ec2.SecurityGroupProps{
+		Vpc:         vpc,
+		Description: jsii.String("Route 53 Resolver endpoints"),
+	})
+	securityGroup.AddIngressRule(ec2.Peer_Ipv4(jsii.String(OrganizationCidr)), ec2.Port_Udp(jsii.Number(53)), jsii.String("DNS from the organization"), nil)
+	securityGroup.AddIngressRule(ec2.Peer_Ipv4(jsii.String(OrganizationCidr)), ec2.Port_Tcp(jsii.Number(53)), jsii.String("DNS from the organization"), nil)
+
+	var ipAddresses []interface{}
+	for _, subnetId := range subnetIds {
+		ipAddresses = append(ipAddresses, &resolver.CfnResolverEndpoint_IpAddressRequestProperty{SubnetId: subnetId})
+	}
+
+	inbound := resolver.NewCfnResolverEndpoint(stack, jsii.String("InboundResolverEndpoint"), &resolver.CfnResolverEndpointProps{
+		Name:             jsii.String("hub-inbound"),
+		Direction:        jsii.String("INBOUND"),
+		IpAddresses:      ipAddresses,
+		SecurityGroupIds: jsii.Strings(*securityGroup.SecurityGroupId()),
+	})
+	outbound := resolver.NewCfnResolverEndpoint(stack, jsii.String("OutboundResolverEndpoint"), &resolver.CfnResolverEndpointProps{
+		Name:             jsii.String("hub-outbound"),
+		Direction:        jsii.String("OUTBOUND"),
+		IpAddresses:      ipAddresses,
+		SecurityGroupIds: jsii.Strings(*securityGroup.SecurityGroupId()),
+	})
+
+	// CloudFormation does not return the IPs of an endpoint, so they are
+	// looked up for the on-premises DNS server configuration.
+	inboundLookup := cr.NewAwsCustomResource(stack, jsii.String("InboundResolverEndpointLookup"), &cr.AwsCustomResourceProps{
+		OnUpdate: &cr.AwsSdkCall{
+			Service: jsii.String("Route53Resolver"),
+			Action:  jsii.String("listResolverEndpointIpAddresses"),
+			Parameters: map[string]interface{}{
+				"ResolverEndpointId": inbound.AttrResolverEndpointId(),
+			},
+			PhysicalResourceId: cr.PhysicalResourceId_Of(inbound.AttrResolverEndpointId()),
+		},
+		Policy: cr.AwsCustomResourcePolicy_FromSdkCalls(&cr.SdkCallsPolicyOptions{
+			Resources: cr.AwsCustomResourcePolicy_ANY_RESOURCE(),
+		}),
+		InstallLatestAwsSdk: jsii.Bool(false),
+	})
+	for i := range subnetIds {
+		awscdk.NewCfnOutput(stack, jsii.String(fmt.Sprintf("inbound-resolver-ip%d-output", i+1)), &awscdk.CfnOutputProps{
+			Value:       inboundLookup.GetResponseField(jsii.String(fmt.Sprintf("IpAddresses.%d.Ip", i))),
+			Description: jsii.String("Inbound Resolver endpoint IP, the forwarder for AWS private zones"),
+		})
+	}
+
+	var ruleArns []*string
+	for _, rule := range config.forwardingRules {
+		name := strings.ReplaceAll(strings.TrimSuffix(rule.domainName, "."), ".", "-")
+		warnDnsTargetsUnrouted(stack, rule)
+
+		resolverRule := resolver.NewCfnResolverRule(stack, jsii.String(fmt.Sprintf("ForwardingRule-%s", name)), &resolver.CfnResolverRuleProps{
+			Name:               jsii.String(name),
+			DomainName:         jsii.String(rule.domainName),
+			RuleType:           jsii.String("FORWARD"),
+			ResolverEndpointId: outbound.AttrResolverEndpointId(),
+			TargetIps:          forwardingTargets(rule),
+		})
+		ruleArns = append(ruleArns, resolverRule.AttrArn())
+
+		resolver.NewCfnResolverRuleAssociation(stack, jsii.String(fmt.Sprintf("ForwardingRule-%s-SharedServices", name)), &resolver.CfnResolverRuleAssociationProps{
+			ResolverRuleId: resolverRule.AttrResolverRuleId(),
+			VpcId:          vpc.VpcId(),
+		})
+		for i, spokeVpcId := range spokeVpcIds {
+			resolver.NewCfnResolverRuleAssociation(stack, jsii.String(fmt.Sprintf("ForwardingRule-%s-Spoke%d", name, i+1)), &resolver.CfnResolverRuleAssociationProps{
+				ResolverRuleId: resolverRule.AttrResolverRuleId(),
+				VpcId:          spokeVpcId,
+			})
+		}
+	}
+
+	// Spokes in other accounts associate the shared rules with their VPCs.
+	if len(config.sharePrincipals) > 0 && len(ruleArns) > 0 {
+		ram.NewCfnResourceShare(stack, jsii.String("ForwardingRulesShare"), &ram.CfnResourceShareProps{
+			Name:         jsii.String("HubForwardingRules"),
+			Principals:   jsii.Strings(config.sharePrincipals...),
+			ResourceArns: &ruleArns,
+		})
+	}
+}
+
+func forwardingTargets(rule ForwardingRule) []interface{} {
+	var targets []interface{}
+	for _, target := range rule.targetIps {
+		address := &resolver.CfnResolverRule_TargetAddressProperty{Ip: jsii.String(target)}
+		if host, port, err := net.SplitHostPort(target); err == nil {
+			address = &resolver.CfnResolverRule_TargetAddressProperty{Ip: jsii.String(host), Port: jsii.String(port)}
+		}
+		if net.ParseIP(*address.Ip) == nil {
+			panic(fmt.Sprintf("forwarding rule %s: invalid target %q", rule.domainName, target))
+		}
+		targets = append(targets, address)
+	}
+	return targets
+}
+
+// The outbound endpoint reaches the target DNS servers through the
+// shared-services route table and the shared-services VPC only routes the
+// organization CIDR to the transit gateway.
+func warnDnsTargetsUnrouted(stack awscdk.Stack, rule ForwardingRule) {
+	segment := resolvedSegment("shared-services")
+	var routed []string
+	for _, route := range segment.staticRoutes {
+		if route.targetSegment != "" {
+			routed = append(routed, route.destinationCidr)
+		}
+	}
+	for _, other := range resolvedTgwSegments() {
+		for _, target := range other.propagateTo {
+			if target == segment.name {
+				routed = append(routed, other.cidrs...)
+			}
+		}
+	}
+
+	for _, target := range forwardingTargets(rule) {
+		ip := net.ParseIP(*target.(*resolver.CfnResolverRule_TargetAddressProperty).Ip)
+		if !cidrsContain([]string{OrganizationCidr}, ip) || !cidrsContain(routed, ip) {
+			awscdk.Annotations_Of(stack).AddWarning(jsii.String(fmt.Sprintf(
+				"forwarding rule %s: %s is not routed from the shared-services segment, connect it with the segment of the DNS server in TgwConnectivityMatrix",
+				rule.domainName, ip)))
+		}
+	}
+}
+
+func cidrsContain(cidrs []string, ip net.IP) bool {
+	for _, cidr := range cidrs {
+		if _, network, err := net.ParseCIDR(cidr); err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Stateful rules passing DNS through the firewall, numbered from firstSid,
+// when HybridDns is configured.
+func hybridDnsAllowRules(firstSid int) []interface{} {
+	if HybridDns == nil {
+		return nil
+	}
+	var rules []interface{}
+	for i, protocol := range []string{"UDP", "TCP"} {
+		rules = append(rules, &firewall.CfnRuleGroup_StatefulRuleProperty{
+			Action: jsii.String("PASS"),
+			Header: &firewall.CfnRuleGroup_HeaderProperty{
+				Destination:     jsii.String(OrganizationCidr),
+				DestinationPort: jsii.String("53"),
+				Source:          jsii.String(OrganizationCidr),
+				SourcePort:      jsii.String("ANY"),
+				Protocol:        jsii.String(protocol),
+				Direction:       jsii.String("FORWARD"),
+			},
+			RuleOptions: []interface{}{
+				&firewall.CfnRuleGroup_RuleOptionProperty{
+					Keyword: jsii.String(fmt.Sprintf("sid:%d", firstSid+i)),
+				},
+			},
+		})
+	}
+	return rules
+}
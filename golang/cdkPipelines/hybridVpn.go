@@ -0,0 +1,176 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cdkPipelines
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	ec2 "github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
+	cr "github.com/aws/aws-cdk-go/awscdk/v2/customresources"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// Creates a Site-to-Site VPN connection on the transit gateway and wires its
+// attachment into the route tables of its segment. VPN attachments are not
+// handled by the attachment Lambda, so the association and propagations are
+// created here.
+func createVpnAttachment(stack awscdk.Stack, transitGWId *string, vpn VpnConnectionConfig, routeTables map[string]*string) {
+	segmentName := vpn.segment
+	if segmentName == "" {
+		segmentName = "on-prem"
+	}
+	segment := resolvedSegment(segmentName)
+	if vpn.staticRoutesOnly && len(vpn.staticRoutes) == 0 {
+		panic(fmt.Sprintf("VPN %s: static routing needs staticRoutes", vpn.name))
+	}
+	warnOutsideOrganization(stack, vpn)
+
+	customerGateway := ec2.NewCfnCustomerGateway(stack, jsii.String(fmt.Sprintf("CustomerGateway-%s", vpn.name)), &ec2.CfnCustomerGatewayProps{
+		BgpAsn:    jsii.Number(vpn.bgpAsn),
+		IpAddress: jsii.String(vpn.customerGatewayIp),
+		Type:      jsii.String("ipsec.1"),
+		Tags: &[]*awscdk.CfnTag{
+			{
+				Key:   jsii.String("Name"),
+				Value: jsii.String(vpn.name),
+			},
+		},
+	})
+
+	vpnConnection := ec2.NewCfnVPNConnection(stack, jsii.String(fmt.Sprintf("VpnConnection-%s", vpn.name)), &ec2.CfnVPNConnectionProps{
+		CustomerGatewayId: customerGateway.Ref(),
+		TransitGatewayId:  transitGWId,
+		StaticRoutesOnly:  jsii.Bool(vpn.staticRoutesOnly),
+		Type:              jsii.String("ipsec.1"),
+		Tags: &[]*awscdk.CfnTag{
+			{
+				Key:   jsii.String("Name"),
+				Value: jsii.String(vpn.name),
+			},
+			{
+				Key:   jsii.String("routeTable"),
+				Value: jsii.String(segmentName),
+			},
+		},
+	})
+
+	// CloudFormation does not return the transit gateway attachment of a VPN
+	// connection, so it is looked up.
+	attachmentLookup := cr.NewAwsCustomResource(stack, jsii.String(fmt.Sprintf("VpnAttachmentLookup-%s", vpn.name)), &cr.AwsCustomResourceProps{
+		OnUpdate: &cr.AwsSdkCall{
+			Service: jsii.String("EC2"),
+			Action:  jsii.String("describeTransitGatewayAttachments"),
+			Parameters: map[string]interface{}{
+				"Filters": []map[string]interface{}{
+					{"Name": "resource-id", "Values": []*string{vpnConnection.Ref()}},
+					{"Name": "resource-type", "Values": []string{"vpn"}},
+				},
+			},
+			PhysicalResourceId: cr.PhysicalResourceId_FromResponse(jsii.String("TransitGatewayAttachments.0.TransitGatewayAttachmentId")),
+			OutputPaths:        jsii.Strings("TransitGatewayAttachments.0.TransitGatewayAttachmentId"),
+		},
+		Policy: cr.AwsCustomResourcePolicy_FromSdkCalls(&cr.SdkCallsPolicyOptions{
+			Resources: cr.AwsCustomResourcePolicy_ANY_RESOURCE(),
+		}),
+		InstallLatestAwsSdk: jsii.Bool(false),
+	})
+	attachmentId := attachmentLookup.GetResponseField(jsii.String("TransitGatewayAttachments.0.TransitGatewayAttachmentId"))
+
+	ec2.NewCfnTransitGatewayRouteTableAssociation(stack, jsii.String(fmt.Sprintf("VpnAssociation-%s", vpn.name)), &ec2.CfnTransitGatewayRouteTableAssociationProps{
+		TransitGatewayAttachmentId: attachmentId,
+		TransitGatewayRouteTableId: routeTables[segmentName],
+	})
+
+	for _, target := range segment.propagateTo {
+		if vpn.staticRoutesOnly {
+			// Without BGP there are no routes to propagate.
+			for _, cidr := range vpn.staticRoutes {
+				ec2.NewCfnTransitGatewayRoute(stack, jsii.String(fmt.Sprintf("VpnRoute-%s-%s-%s", vpn.name, target, strings.ReplaceAll(cidr, "/", "-"))), &ec2.CfnTransitGatewayRouteProps{
+					DestinationCidrBlock:       jsii.String(cidr),
+					TransitGatewayAttachmentId: attachmentId,
+					TransitGatewayRouteTableId: routeTables[target],
+				})
+			}
+			continue
+		}
+		ec2.NewCfnTransitGatewayRouteTablePropagation(stack, jsii.String(fmt.Sprintf("VpnPropagation-%s-%s", vpn.name, target)), &ec2.CfnTransitGatewayRouteTablePropagationProps{
+			TransitGatewayAttachmentId: attachmentId,
+			TransitGatewayRouteTableId: routeTables[target],
+		})
+	}
+
+	// Outside IPs of the tunnels, for the customer gateway configuration.
+	// The full device configuration, including the pre-shared keys, is
+	// downloaded with `aws ec2 get-vpn-connection-device-sample-configuration`.
+	tunnelLookup := cr.NewAwsCustomResource(stack, jsii.String(fmt.Sprintf("VpnTunnelLookup-%s", vpn.name)), &cr.AwsCustomResourceProps{
+		OnUpdate: &cr.AwsSdkCall{
+			Service: jsii.String("EC2"),
+			Action:  jsii.String("describeVpnConnections"),
+			Parameters: map[string]interface{}{
+				"VpnConnectionIds": []*string{vpnConnection.Ref()},
+			},
+			PhysicalResourceId: cr.PhysicalResourceId_Of(vpnConnection.Ref()),
+			OutputPaths: jsii.Strings(
+				"VpnConnections.0.Options.TunnelOptions.0.OutsideIpAddress",
+				"VpnConnections.0.Options.TunnelOptions.1.OutsideIpAddress",
+			),
+		},
+		Policy: cr.AwsCustomResourcePolicy_FromSdkCalls(&cr.SdkCallsPolicyOptions{
+			Resources: cr.AwsCustomResourcePolicy_ANY_RESOURCE(),
+		}),
+		InstallLatestAwsSdk: jsii.Bool(false),
+	})
+
+	awscdk.NewCfnOutput(stack, jsii.String(fmt.Sprintf("vpn-%s-connection-output", vpn.name)), &awscdk.CfnOutputProps{
+		Value:       vpnConnection.Ref(),
+		Description: jsii.String(fmt.Sprintf("VPN connection %s", vpn.name)),
+	})
+	awscdk.NewCfnOutput(stack, jsii.String(fmt.Sprintf("vpn-%s-attachment-output", vpn.name)), &awscdk.CfnOutputProps{
+		Value:       attachmentId,
+		Description: jsii.String(fmt.Sprintf("Transit gateway attachment of VPN %s", vpn.name)),
+	})
+	for i := 0; i < 2; i++ {
+		awscdk.NewCfnOutput(stack, jsii.String(fmt.Sprintf("vpn-%s-tunnel%d-output", vpn.name, i+1)), &awscdk.CfnOutputProps{
+			Value:       tunnelLookup.GetResponseField(jsii.String(fmt.Sprintf("VpnConnections.0.Options.TunnelOptions.%d.OutsideIpAddress", i))),
+			Description: jsii.String(fmt.Sprintf("Outside IP of tunnel %d of VPN %s", i+1, vpn.name)),
+		})
+	}
+}
+
+// The firewall subnets only route the organization CIDR back to the transit
+// gateway, so return traffic to on-premises CIDRs outside of it is dropped.
+func warnOutsideOrganization(stack awscdk.Stack, vpn VpnConnectionConfig) {
+	_, organization, err := net.ParseCIDR(OrganizationCidr)
+	if err != nil {
+		panic(err)
+	}
+	for _, cidr := range vpn.staticRoutes {
+		ip, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(fmt.Sprintf("VPN %s: invalid CIDR %q", vpn.name, cidr))
+		}
+		orgOnes, _ := organization.Mask.Size()
+		ones, _ := network.Mask.Size()
+		if !organization.Contains(ip) || ones < orgOnes {
+			awscdk.Annotations_Of(stack).AddWarning(jsii.String(fmt.Sprintf(
+				"VPN %s: %s is outside the organization CIDR %s and is not routed back from the inspection VPC",
+				vpn.name, cidr, OrganizationCidr)))
+		}
+	}
+}
@@ -16,8 +16,11 @@
 package cdkPipelines
 
 import (
+	"fmt"
+
 	"github.com/aws/aws-cdk-go/awscdk/v2"
 	"github.com/aws/constructs-go/constructs/v10"
+	"github.com/aws/jsii-runtime-go"
 )
 
 type NetworkWorkshopInspectStageProps struct {
@@ -32,22 +35,99 @@ func NetworkWorkshopInspectStage(scope constructs.Construct, id string, props *N
 
 	stage := awscdk.NewStage(scope, &id, &sprops)
 
-	tgw := InspectionTgwStack(stage, "TransitGateway", nil)
+	tgw := InspectionTgwStack(stage, "TransitGateway", &InspectionTgwStackProps{
+		vpnConnections: VpnConnections,
+	})
 
-	NetworkFirewallStack(stage, "Inspection", &NetworkFirewallStackProps{
-		cidr:        "10.100.0.0/16",
-		orgCidr:     OrganizationCidr,
-		transitGWId: tgw.tgWId,
+	inspection := NetworkFirewallStack(stage, "Inspection", &NetworkFirewallStackProps{
+		cidr:                     "10.100.0.0/16",
+		orgCidr:                  OrganizationCidr,
+		transitGWId:              tgw.tgWId,
+		azs:                      HubAzs,
+		nativeEndpointRoutes:     UseNativeFirewallRoutes,
+		endpointReadinessTimeout: FirewallEndpointReadinessTimeoutMinutes,
+		dualStack:                DualStack,
 	})
 
-	InspectionWorkloadStack(stage, "Workload1", &InspectionWorkloadStackProps{
-		cidr:        "10.110.0.0/16",
-		transitGWId: tgw.tgWId,
+	// The rule group is deployed by the FirewallRules stage, which runs first.
+	var dnsFirewallRuleGroupId *string
+	if dnsFirewallEnabled(EgressDomainLists) {
+		dnsFirewallRuleGroupId = awscdk.Fn_ImportValue(jsii.String(DnsFirewallRuleGroupExportName))
+	}
+
+	workload1 := InspectionWorkloadStack(stage, "Workload1", &InspectionWorkloadStackProps{
+		cidr:                       "10.110.0.0/16",
+		transitGWId:                tgw.tgWId,
+		azs:                        SpokeAzs,
+		centralizedEndpoints:       SharedServices != nil,
+		dnsFirewallRuleGroupId:     dnsFirewallRuleGroupId,
+		workload:                   SpokeWorkload,
+		dedicatedAttachmentSubnets: SpokeDedicatedAttachmentSubnets,
+		dualStack:                  DualStack,
 	})
 
-	InspectionWorkloadStack(stage, "Workload2", &InspectionWorkloadStackProps{
-		cidr:        "10.111.0.0/16",
-		transitGWId: tgw.tgWId,
+	workload2 := InspectionWorkloadStack(stage, "Workload2", &InspectionWorkloadStackProps{
+		cidr:                       "10.111.0.0/16",
+		transitGWId:                tgw.tgWId,
+		azs:                        SpokeAzs,
+		centralizedEndpoints:       SharedServices != nil,
+		dnsFirewallRuleGroupId:     dnsFirewallRuleGroupId,
+		workload:                   SpokeWorkload,
+		dedicatedAttachmentSubnets: SpokeDedicatedAttachmentSubnets,
+		dualStack:                  DualStack,
+	})
+
+	spokes := []InspectionWorkloadStackOutputs{workload1, workload2}
+	// Brownfield VPCs may already have their own endpoints and DNS setup, so
+	// they are not associated with the shared-services zones and rules.
+	var brownfieldSpokes []InspectionWorkloadStackOutputs
+	for _, brownfield := range BrownfieldSpokes {
+		brownfieldSpokes = append(brownfieldSpokes, BrownfieldSpokeStack(stage, fmt.Sprintf("Spoke-%s", brownfield.name), &BrownfieldSpokeStackProps{
+			spoke:       brownfield,
+			transitGWId: tgw.tgWId,
+		}))
+	}
+
+	if SharedServices != nil {
+		SharedServicesStack(stage, "SharedServices", &SharedServicesStackProps{
+			cidr:             SharedServices.cidr,
+			transitGWId:      tgw.tgWId,
+			endpointServices: SharedServices.endpointServices,
+			spokes:           spokes,
+			hybridDns:        HybridDns,
+		})
+	} else if HybridDns != nil {
+		panic("HybridDns requires SharedServices, the Resolver endpoints are created in the shared-services VPC")
+	}
+
+	for _, spoke := range append(spokes, brownfieldSpokes...) {
+		validateSpokeAzs(inspection, spoke)
+	}
+
+	return stage
+}
+
+type FirewallRulesStageProps struct {
+	awscdk.StageProps
+}
+
+// The rule groups and firewall policy live in their own stage so rule-only
+// changes can be rolled out without touching the networking stacks.
+func FirewallRulesStage(scope constructs.Construct, id string, props *FirewallRulesStageProps) awscdk.Stage {
+	var sprops awscdk.StageProps
+	if props != nil {
+		sprops = props.StageProps
+	}
+
+	stage := awscdk.NewStage(scope, &id, &sprops)
+
+	// A fixed stack name makes both pipelines update the same CloudFormation
+	// stack.
+	NetworkFirewallRules(stage, "FirewallRules", &FirewallRuleStackProps{
+		StackProps: awscdk.StackProps{
+			StackName: jsii.String(FirewallRulesStackName),
+		},
+		sharePrincipals: FirewallRulesSharePrincipals,
 	})
 
 	return stage
@@ -35,9 +35,32 @@ type NetworkFirewallStackProps struct {
 	cidr        string
 	orgCidr     string
 	transitGWId *string
+	azs         VpcAzConfig
+	// Subnet masks of the inspection VPC tiers. Default to /26 for the TGW
+	// subnets and /27 for the firewall and public subnets.
+	tgwSubnetMask      float64
+	firewallSubnetMask float64
+	publicSubnetMask   float64
+	// Resolve the firewall endpoints with intrinsic functions and create
+	// plain routes instead of using the route custom resource Lambda.
+	nativeEndpointRoutes bool
+	// Minutes the route custom resources wait for the firewall endpoints to
+	// become READY, defaults to 30.
+	endpointReadinessTimeout float64
+	// ARN of the firewall policy. Defaults to the active policy version
+	// exported by the FirewallRules stack.
+	fwPolicyArn *string
+	// Dual-stack VPC, IPv4 only when nil.
+	dualStack *Ipv6Config
 }
 
-func NetworkFirewallStack(scope constructs.Construct, id string, props *NetworkFirewallStackProps) {
+type NetworkFirewallStackOutputs struct {
+	awscdk.Stack
+	account           string
+	availabilityZones *[]*string
+}
+
+func NetworkFirewallStack(scope constructs.Construct, id string, props *NetworkFirewallStackProps) NetworkFirewallStackOutputs {
 
 	var sprops awscdk.StackProps
 	if props != nil {
@@ -45,38 +68,49 @@ func NetworkFirewallStack(scope constructs.Construct, id string, props *NetworkF
 	}
 	stack := awscdk.NewStack(scope, &id, &sprops)
 
-	vpc := ec2.NewVpc(stack, jsii.String("InspectionVPC"), &ec2.VpcProps{
+	vpcProps := &ec2.VpcProps{
 		IpAddresses: ec2.IpAddresses_Cidr(&props.cidr),
 		SubnetConfiguration: &[]*ec2.SubnetConfiguration{
 			{
 				Name:       jsii.String("Tgw_Subnet"),
 				SubnetType: ec2.SubnetType_PRIVATE_ISOLATED,
-				CidrMask:   jsii.Number(26),
+				CidrMask:   subnetMask(props.tgwSubnetMask, 26),
 			},
 			{
 				Name:       jsii.String("Firewall_Subnet"),
 				SubnetType: ec2.SubnetType_PRIVATE_WITH_EGRESS,
-				CidrMask:   jsii.Number(27),
+				CidrMask:   subnetMask(props.firewallSubnetMask, 27),
 			},
 			{
 				Name:       jsii.String("Public"),
 				SubnetType: ec2.SubnetType_PUBLIC,
-				CidrMask:   jsii.Number(27),
+				CidrMask:   subnetMask(props.publicSubnetMask, 27),
 			},
 		},
-	})
+	}
+	applyAzConfig(vpcProps, props.azs, stackAccount(stack))
+
+	vpc := ec2.NewVpc(stack, jsii.String("InspectionVPC"), vpcProps)
+	if props.dualStack != nil {
+		enableDualStack(stack, vpc, *props.dualStack)
+	}
 
 	tGWSubnetIDs := vpc.SelectSubnets(&ec2.SubnetSelection{
 		SubnetGroupName: jsii.String("Tgw_Subnet"),
 	}).SubnetIds
 
+	attachmentOptions := map[string]string{
+		"ApplianceModeSupport": "enable",
+	}
+	if props.dualStack != nil {
+		attachmentOptions["Ipv6Support"] = "enable"
+	}
+
 	tGWAttachment := ec2.NewCfnTransitGatewayAttachment(stack, jsii.String("TGW_Attachment"), &ec2.CfnTransitGatewayAttachmentProps{
 		TransitGatewayId: props.transitGWId,
 		SubnetIds:        tGWSubnetIDs,
 		VpcId:            vpc.VpcId(),
-		Options: map[string]string{
-			"ApplianceModeSupport": "enable",
-		},
+		Options:          attachmentOptions,
 		Tags: &[]*awscdk.CfnTag{
 			{
 				Key:   jsii.String("routeTable"),
@@ -90,8 +124,19 @@ func NetworkFirewallStack(scope constructs.Construct, id string, props *NetworkF
 		TransitGatewayAttachmentId: tGWAttachment.AttrId(),
 		TransitGatewayRouteTableId: awscdk.Fn_ImportValue(jsii.String("WorkloadRouteTableId")),
 	})
+	if props.dualStack != nil {
+		ec2.NewCfnTransitGatewayRoute(stack, jsii.String("TGW_Route_Ipv6"), &ec2.CfnTransitGatewayRouteProps{
+			DestinationCidrBlock:       jsii.String("::/0"),
+			TransitGatewayAttachmentId: tGWAttachment.AttrId(),
+			TransitGatewayRouteTableId: awscdk.Fn_ImportValue(jsii.String("WorkloadRouteTableId")),
+		})
+	}
+	createSegmentStaticRoutes(stack, "inspection", tGWAttachment.AttrId())
 
-	firewallRules := NetworkFirewallRules(stack, "NetworkFirewallRules", nil)
+	fwPolicyArn := props.fwPolicyArn
+	if fwPolicyArn == nil {
+		fwPolicyArn = awscdk.Fn_ImportValue(jsii.String(FirewallPolicyExportName(ActiveFirewallPolicyVersion)))
+	}
 
 	fwSubnets := vpc.SelectSubnetObjects(&ec2.SubnetSelection{
 		SubnetGroupName: jsii.String("Firewall_Subnet"),
@@ -107,7 +152,7 @@ func NetworkFirewallStack(scope constructs.Construct, id string, props *NetworkF
 
 	networkFw := nf.NewCfnFirewall(stack, jsii.String("Network_Firewall"), &nf.CfnFirewallProps{
 		FirewallName:      jsii.String("EgressInspectionFirewall"),
-		FirewallPolicyArn: firewallRules.fwPolicyArn,
+		FirewallPolicyArn: fwPolicyArn,
 		SubnetMappings:    fwSubnetList,
 		VpcId:             vpc.VpcId(),
 	})
@@ -144,6 +189,56 @@ func NetworkFirewallStack(scope constructs.Construct, id string, props *NetworkF
 		},
 	})
 
+	CreatePolicySwitchHealthCheck(stack, &PolicySwitchHealthCheckProps{
+		firewall:          networkFw,
+		firewallName:      networkFw.FirewallName(),
+		firewallPolicyArn: fwPolicyArn,
+		alertLogGroup:     fwAlertLogsGroup,
+		availabilityZones: vpc.AvailabilityZones(),
+		config:            FirewallHealthCheck,
+	})
+
+	// Default routes towards the firewall endpoints from the TGW subnets and
+	// return routes for the organization CIDR from the public subnets. The
+	// custom resources stay the default for existing deployments. Switching
+	// an existing deployment to native routes needs the custom resource
+	// routes removed first, as both manage the same destinations.
+	readinessTimeout := props.endpointReadinessTimeout
+	if readinessTimeout == 0 {
+		readinessTimeout = 30
+	}
+	if props.nativeEndpointRoutes {
+		createNativeFirewallRoutes(stack, vpc, networkFw, props.orgCidr, props.dualStack != nil)
+	} else {
+		createFirewallRouteCustomResources(stack, vpc, networkFw, props.orgCidr, readinessTimeout, props.dualStack != nil)
+	}
+
+	fwSubs := vpc.SelectSubnetObjects(&ec2.SubnetSelection{
+		SubnetGroupName: jsii.String("Firewall_Subnet"),
+	})
+
+	// Create a Route for each FW subnet
+	for _, subnet := range *fwSubs {
+		// Create CloudFormation custom resource to update firewall routing
+		ec2.NewCfnRoute(stack, jsii.String(fmt.Sprintf("OrganisationRoute-%s", strings.SplitN(*subnet.Node().Path(), "/", 1))), &ec2.CfnRouteProps{
+			RouteTableId:         subnet.RouteTable().RouteTableId(),
+			DestinationCidrBlock: &props.orgCidr,
+			TransitGatewayId:     props.transitGWId,
+		}).AddDependency(tGWAttachment)
+	}
+	if props.dualStack != nil {
+		createIpv6FirewallSubnetRoutes(stack, vpc, props.transitGWId, tGWAttachment, *props.dualStack)
+	}
+
+	var outputs NetworkFirewallStackOutputs
+	outputs.Stack = stack
+	outputs.account = stackAccount(stack)
+	outputs.availabilityZones = vpc.AvailabilityZones()
+
+	return outputs
+}
+
+func createFirewallRouteCustomResources(stack awscdk.Stack, vpc ec2.Vpc, networkFw nf.CfnFirewall, orgCidr string, readinessTimeout float64, ipv6 bool) {
 	RouteLambdaRole := iam.NewRole(stack, jsii.String("routeLambdaRole"), &iam.RoleProps{
 		AssumedBy: iam.NewServicePrincipal(jsii.String("lambda.amazonaws.com"), nil),
 		Path:      jsii.String("/"),
@@ -152,6 +247,16 @@ func NetworkFirewallStack(scope constructs.Construct, id string, props *NetworkF
 		},
 	})
 
+	RouteLambdaRole.AddToPolicy(
+		iam.NewPolicyStatement(&iam.PolicyStatementProps{
+			Actions: jsii.Strings("ec2:DescribeAvailabilityZones"),
+			Effect:  iam.Effect_ALLOW,
+			Resources: &[]*string{
+				jsii.String("*"),
+			},
+		}),
+	)
+
 	RouteLambdaRole.AddToPolicy(
 		iam.NewPolicyStatement(&iam.PolicyStatementProps{
 			Actions: jsii.Strings("network-firewall:DescribeFirewall"),
@@ -197,23 +302,38 @@ func NetworkFirewallStack(scope constructs.Construct, id string, props *NetworkF
 
 	RouteLambdaRole.AddToPolicy(
 		iam.NewPolicyStatement(&iam.PolicyStatementProps{
-			Actions:   jsii.Strings("ec2:CreateRoute", "ec2:DeleteRoute"),
+			Actions:   jsii.Strings("ec2:CreateRoute", "ec2:ReplaceRoute", "ec2:DeleteRoute"),
 			Effect:    iam.Effect_ALLOW,
 			Resources: &listArns,
 		}),
 	)
 
 	customRouteLambda := lambda.NewFunction(stack, jsii.String("RoutesFunction"), &lambda.FunctionProps{
-		Runtime: lambda.Runtime_PYTHON_3_9(),
-		Handler: jsii.String("index.lambda_handler"),
-		Role:    RouteLambdaRole,
-		Timeout: awscdk.Duration_Seconds(jsii.Number(60)),
-		Code:    lambda.Code_FromAsset(jsii.String("lambda/routes"), nil),
+		Runtime:      goLambdaRuntime(),
+		Architecture: goLambdaArchitecture(),
+		Handler:      jsii.String("onEvent"),
+		Role:         RouteLambdaRole,
+		Timeout:      awscdk.Duration_Seconds(jsii.Number(60)),
+		Code:         goLambdaCode("./lambda/routes"),
+	})
+
+	// Polls the firewall until the endpoints in every AZ are READY before
+	// creating the route.
+	routeReadyLambda := lambda.NewFunction(stack, jsii.String("RoutesReadyFunction"), &lambda.FunctionProps{
+		Runtime:      goLambdaRuntime(),
+		Architecture: goLambdaArchitecture(),
+		Handler:      jsii.String("isComplete"),
+		Role:         RouteLambdaRole,
+		Timeout:      awscdk.Duration_Seconds(jsii.Number(60)),
+		Code:         goLambdaCode("./lambda/routes"),
 	})
 
 	customResource := cr.NewProvider(stack, jsii.String("provider"), &cr.ProviderProps{
-		OnEventHandler: customRouteLambda,
-		LogRetention:   logs.RetentionDays_ONE_DAY,
+		OnEventHandler:    customRouteLambda,
+		IsCompleteHandler: routeReadyLambda,
+		QueryInterval:     awscdk.Duration_Seconds(jsii.Number(30)),
+		TotalTimeout:      awscdk.Duration_Minutes(jsii.Number(readinessTimeout + 5)),
+		LogRetention:      logs.RetentionDays_ONE_DAY,
 	})
 
 	// TODO: not copied over the dependency on the Lambda as it should be
@@ -234,12 +354,27 @@ func NetworkFirewallStack(scope constructs.Construct, id string, props *NetworkF
 		awscdk.NewCustomResource(stack, jsii.String(fmt.Sprintf("FirewallRoute-%s", strings.SplitN(*subnet.Node().Path(), "/", 1))), &awscdk.CustomResourceProps{
 			ServiceToken: customResource.ServiceToken(),
 			Properties: &map[string]interface{}{
-				"FirewallArn":     networkFw.AttrFirewallArn(),
-				"SubnetAz":        subnet.AvailabilityZone(),
-				"RouteTableId":    subnet.RouteTable().RouteTableId(),
-				"DestinationCidr": "0.0.0.0/0",
+				"FirewallArn":             networkFw.AttrFirewallArn(),
+				"SubnetAz":                subnet.AvailabilityZone(),
+				"RouteTableId":            subnet.RouteTable().RouteTableId(),
+				"DestinationCidr":         "0.0.0.0/0",
+				"SubnetAzId":              subnetAzId(stack, subnet),
+				"ReadinessTimeoutMinutes": readinessTimeout,
 			},
 		})
+		if ipv6 {
+			awscdk.NewCustomResource(stack, jsii.String(fmt.Sprintf("FirewallRouteIpv6-%s", strings.SplitN(*subnet.Node().Path(), "/", 1))), &awscdk.CustomResourceProps{
+				ServiceToken: customResource.ServiceToken(),
+				Properties: &map[string]interface{}{
+					"FirewallArn":             networkFw.AttrFirewallArn(),
+					"SubnetAz":                subnet.AvailabilityZone(),
+					"RouteTableId":            subnet.RouteTable().RouteTableId(),
+					"DestinationCidr":         "::/0",
+					"SubnetAzId":              subnetAzId(stack, subnet),
+					"ReadinessTimeoutMinutes": readinessTimeout,
+				},
+			})
+		}
 	}
 
 	pubSubs := vpc.SelectSubnetObjects(&ec2.SubnetSelection{
@@ -252,25 +387,13 @@ func NetworkFirewallStack(scope constructs.Construct, id string, props *NetworkF
 		awscdk.NewCustomResource(stack, jsii.String(fmt.Sprintf("ReturnRoute-%s", strings.SplitN(*subnet.Node().Path(), "/", 1))), &awscdk.CustomResourceProps{
 			ServiceToken: customResource.ServiceToken(),
 			Properties: &map[string]interface{}{
-				"FirewallArn":     networkFw.AttrFirewallArn(),
-				"SubnetAz":        subnet.AvailabilityZone(),
-				"RouteTableId":    subnet.RouteTable().RouteTableId(),
-				"DestinationCidr": props.orgCidr,
+				"FirewallArn":             networkFw.AttrFirewallArn(),
+				"SubnetAz":                subnet.AvailabilityZone(),
+				"RouteTableId":            subnet.RouteTable().RouteTableId(),
+				"DestinationCidr":         orgCidr,
+				"SubnetAzId":              subnetAzId(stack, subnet),
+				"ReadinessTimeoutMinutes": readinessTimeout,
 			},
 		})
 	}
-
-	fwSubs := vpc.SelectSubnetObjects(&ec2.SubnetSelection{
-		SubnetGroupName: jsii.String("Firewall_Subnet"),
-	})
-
-	// Create a Route for each FW subnet
-	for _, subnet := range *fwSubs {
-		// Create CloudFormation custom resource to update firewall routing
-		ec2.NewCfnRoute(stack, jsii.String(fmt.Sprintf("OrganisationRoute-%s", strings.SplitN(*subnet.Node().Path(), "/", 1))), &ec2.CfnRouteProps{
-			RouteTableId:         subnet.RouteTable().RouteTableId(),
-			DestinationCidrBlock: &props.orgCidr,
-			TransitGatewayId:     props.transitGWId,
-		}).AddDependency(tGWAttachment)
-	}
 }
@@ -16,18 +16,22 @@
 package cdkPipelines
 
 import (
+	"strings"
+
 	"github.com/aws/aws-cdk-go/awscdk/v2"
 	ec2 "github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsevents"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awseventstargets"
 	iam "github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
 	lambda "github.com/aws/aws-cdk-go/awscdk/v2/awslambda"
+	ssm "github.com/aws/aws-cdk-go/awscdk/v2/awsssm"
 	"github.com/aws/constructs-go/constructs/v10"
 	"github.com/aws/jsii-runtime-go"
 )
 
 type InspectionTgwStackProps struct {
 	awscdk.StackProps
+	vpnConnections []VpnConnectionConfig
 }
 
 type InspectionTgwStackOutputs struct {
@@ -37,29 +41,21 @@ type InspectionTgwStackOutputs struct {
 
 func InspectionTgwStack(scope constructs.Construct, id string, props *InspectionTgwStackProps) InspectionTgwStackOutputs {
 	var sprops awscdk.StackProps
+	var vpnConnections []VpnConnectionConfig
 	if props != nil {
 		sprops = props.StackProps
+		vpnConnections = props.vpnConnections
 	}
 	stack := awscdk.NewStack(scope, &id, &sprops)
 
-	TransitGateway := ec2.NewCfnTransitGateway(stack, jsii.String("TransitGateway"), &ec2.CfnTransitGatewayProps{
-		Description:                  jsii.String("TransitGateway"), //To do: Region suffix
-		DefaultRouteTableAssociation: jsii.String("disable"),
-		DefaultRouteTablePropagation: jsii.String("disable"),
-		Tags: &[]*awscdk.CfnTag{
-			{
-				Key:   jsii.String("Name"),
-				Value: jsii.String("transit-gateway"), //To do: Region suffix/prefix
-			},
-		},
-	})
+	TransitGateway := ec2.NewCfnTransitGateway(stack, jsii.String("TransitGateway"), transitGatewayProps(stack, TgwOptions, Naming))
 
 	WorkLoadRt := ec2.NewCfnTransitGatewayRouteTable(stack, jsii.String("WorkloadRouteTable"), &ec2.CfnTransitGatewayRouteTableProps{
 		TransitGatewayId: TransitGateway.AttrId(),
 		Tags: &[]*awscdk.CfnTag{
 			{
 				Key:   jsii.String("Name"),
-				Value: jsii.String("Workload"), //To do: Region suffix/prefix
+				Value: Naming.name(stack, segmentRouteTableName("workload")),
 			},
 		},
 	})
@@ -74,7 +70,7 @@ func InspectionTgwStack(scope constructs.Construct, id string, props *Inspection
 		Tags: &[]*awscdk.CfnTag{
 			{
 				Key:   jsii.String("Name"),
-				Value: jsii.String("InspectionRouteTable"), //To do: Region suffix/prefix
+				Value: Naming.name(stack, segmentRouteTableName("inspection")),
 			},
 		},
 	})
@@ -83,9 +79,48 @@ func InspectionTgwStack(scope constructs.Construct, id string, props *Inspection
 		ExportName: jsii.String("InspectionRouteTableId"),
 	})
 
+	// Attachments without a known routeTable tag are associated here. The
+	// route table has no routes, so they stay isolated until tagged.
+	QuarantineRt := ec2.NewCfnTransitGatewayRouteTable(stack, jsii.String("quarantine-route-table"), &ec2.CfnTransitGatewayRouteTableProps{
+		TransitGatewayId: TransitGateway.AttrId(),
+		Tags: &[]*awscdk.CfnTag{
+			{
+				Key:   jsii.String("Name"),
+				Value: Naming.name(stack, "quarantine-route-table"),
+			},
+		},
+	})
+	awscdk.NewCfnOutput(stack, jsii.String("quarantine-rt-output"), &awscdk.CfnOutputProps{
+		Value:      QuarantineRt.Ref(),
+		ExportName: jsii.String("QuarantineRouteTableId"),
+	})
+
+	if TgwFlowLogs.enabled {
+		createTgwFlowLogs(stack, TransitGateway.AttrId(), TgwFlowLogs)
+	}
+
+	validateTgwSegments()
+	routeTables := createSegmentRouteTables(stack, TransitGateway.AttrId(), Naming, map[string]*string{
+		"workload":   WorkLoadRt.Ref(),
+		"inspection": InspectionRt.Ref(),
+	})
+	createSegmentBlackholeRoutes(stack, routeTables)
+	assertSpokeIsolation(stack)
+
+	for _, vpn := range vpnConnections {
+		createVpnAttachment(stack, TransitGateway.AttrId(), vpn, routeTables)
+	}
+	if DirectConnect != nil {
+		createDirectConnectAssociation(stack, TransitGateway.AttrId(), *DirectConnect, routeTables)
+	}
+	if SdWan != nil {
+		createSdWanConnect(stack, TransitGateway.AttrId(), *SdWan, routeTables, TgwOptions.cidrBlocks)
+	}
+	segmentsParameter := publishSegmentMapping(stack, routeTables, QuarantineRt.Ref())
+
 	//To do/check from Py project: Self.TransitGateway = TransitGateway
 
-	CreateEventHandling(stack)
+	CreateEventHandling(stack, segmentsParameter)
 
 	var outputs InspectionTgwStackOutputs
 	outputs.Stack = stack
@@ -94,8 +129,55 @@ func InspectionTgwStack(scope constructs.Construct, id string, props *Inspection
 	return outputs
 }
 
-func CreateEventHandling(scope constructs.Construct) {
-	AWSLambdaBasicExecPolicy := iam.ManagedPolicy_FromAwsManagedPolicyName(jsii.String("AmazonSSMManagedInstanceCore"))
+// Settings that replace the transit gateway when changed, like multicast
+// support, are only set when they differ from the defaults, so existing
+// transit gateways are kept.
+func transitGatewayProps(stack awscdk.Stack, options TransitGatewayOptions, naming ResourceNaming) *ec2.CfnTransitGatewayProps {
+	enable := func(enabled bool) *string {
+		if enabled {
+			return jsii.String("enable")
+		}
+		return jsii.String("disable")
+	}
+
+	props := &ec2.CfnTransitGatewayProps{
+		Description:                  naming.name(stack, "transit-gateway"),
+		DefaultRouteTableAssociation: jsii.String("disable"),
+		DefaultRouteTablePropagation: jsii.String("disable"),
+		DnsSupport:                   enable(options.dnsSupport),
+		VpnEcmpSupport:               enable(options.vpnEcmpSupport),
+		AutoAcceptSharedAttachments:  enable(options.autoAcceptSharedAttachments),
+		Tags: &[]*awscdk.CfnTag{
+			{
+				Key:   jsii.String("Name"),
+				Value: naming.name(stack, "transit-gateway"),
+			},
+		},
+	}
+	if options.amazonSideAsn != 0 {
+		props.AmazonSideAsn = jsii.Number(options.amazonSideAsn)
+	}
+	if options.multicastSupport {
+		props.MulticastSupport = enable(true)
+	}
+	if len(options.cidrBlocks) > 0 {
+		props.TransitGatewayCidrBlocks = jsii.Strings(options.cidrBlocks...)
+	}
+	return props
+}
+
+// Name of a resource with the environment and region prefix.
+func (n ResourceNaming) name(stack awscdk.Stack, resource string) *string {
+	parts := []string{}
+	if n.environment != "" {
+		parts = append(parts, n.environment)
+	}
+	parts = append(parts, *stack.Region(), resource)
+	return jsii.String(strings.Join(parts, "-"))
+}
+
+func CreateEventHandling(scope constructs.Construct, segmentsParameter ssm.IStringParameter) {
+	AWSLambdaBasicExecPolicy := iam.ManagedPolicy_FromAwsManagedPolicyName(jsii.String("service-role/AWSLambdaBasicExecutionRole"))
 
 	AttachmentLambdaRole := iam.NewRole(scope, jsii.String("attachmentLambdaRole"), &iam.RoleProps{
 		AssumedBy:       iam.NewServicePrincipal(jsii.String("lambda.amazonaws.com"), nil),
@@ -109,7 +191,12 @@ func CreateEventHandling(scope constructs.Construct) {
 				jsii.String("ec2:DescribeTransitGatewayAttachments"),
 				jsii.String("ec2:DisassociateTransitGatewayRouteTable"),
 				jsii.String("ec2:EnableTransitGatewayRouteTablePropagation"),
-				jsii.String("cloudformation:ListExports"),
+				jsii.String("ec2:DisableTransitGatewayRouteTablePropagation"),
+				jsii.String("ec2:GetTransitGatewayAttachmentPropagations"),
+				jsii.String("ec2:CreateTransitGatewayRoute"),
+				jsii.String("ec2:ReplaceTransitGatewayRoute"),
+				jsii.String("ec2:DeleteTransitGatewayRoute"),
+				jsii.String("ec2:SearchTransitGatewayRoutes"),
 			},
 			Effect: iam.Effect_ALLOW,
 			Resources: &[]*string{
@@ -119,12 +206,17 @@ func CreateEventHandling(scope constructs.Construct) {
 	)
 
 	TgwRouteLambda := lambda.NewFunction(scope, jsii.String("TGWAttachmentFunction"), &lambda.FunctionProps{
-		Runtime: lambda.Runtime_PYTHON_3_9(),
-		Handler: jsii.String("index.handler"),
-		Role:    AttachmentLambdaRole,
-		Timeout: awscdk.Duration_Seconds(jsii.Number(60)),
-		Code:    lambda.Code_FromAsset(jsii.String("lambda/attachment"), nil),
+		Runtime:      goLambdaRuntime(),
+		Architecture: goLambdaArchitecture(),
+		Handler:      jsii.String("bootstrap"),
+		Role:         AttachmentLambdaRole,
+		Timeout:      awscdk.Duration_Seconds(jsii.Number(60)),
+		Code:         goLambdaCode("./lambda/attachment"),
+		Environment: &map[string]*string{
+			"SEGMENTS_PARAMETER": segmentsParameter.ParameterName(),
+		},
 	})
+	segmentsParameter.GrantRead(AttachmentLambdaRole)
 
 	EventPatternRule := awsevents.NewRule(scope, jsii.String("TGWAttachmentCreated"), &awsevents.RuleProps{
 		EventPattern: &awsevents.EventPattern{
@@ -132,11 +224,36 @@ func CreateEventHandling(scope constructs.Construct) {
 				jsii.String("aws.ec2"),
 			},
 			Detail: &map[string]interface{}{
-				"eventName": []interface{}{"CreateTransitGatewayVpcAttachment"},
+				"eventName": []interface{}{
+					"CreateTransitGatewayVpcAttachment",
+					"DeleteTransitGatewayVpcAttachment",
+				},
 			},
 		},
 	})
 
 	EventPatternRule.AddTarget(awseventstargets.NewLambdaFunction(TgwRouteLambda, nil))
 
+	TagsChangedRule := awsevents.NewRule(scope, jsii.String("TGWAttachmentTagsChanged"), &awsevents.RuleProps{
+		EventPattern: &awsevents.EventPattern{
+			Source: &[]*string{
+				jsii.String("aws.ec2"),
+			},
+			Detail: &map[string]interface{}{
+				"eventName": []interface{}{"CreateTags", "DeleteTags"},
+				"requestParameters": map[string]interface{}{
+					"resourcesSet": map[string]interface{}{
+						"items": map[string]interface{}{
+							"resourceId": []interface{}{
+								map[string]interface{}{"prefix": "tgw-attach-"},
+							},
+						},
+					},
+				},
+			},
+		},
+	})
+
+	TagsChangedRule.AddTarget(awseventstargets.NewLambdaFunction(TgwRouteLambda, nil))
+
 }
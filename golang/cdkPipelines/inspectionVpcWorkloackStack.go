@@ -21,7 +21,7 @@ import (
 
 	"github.com/aws/aws-cdk-go/awscdk/v2"
 	ec2 "github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
-	iam "github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	resolver "github.com/aws/aws-cdk-go/awscdk/v2/awsroute53resolver"
 	"github.com/aws/constructs-go/constructs/v10"
 	"github.com/aws/jsii-runtime-go"
 )
@@ -30,9 +30,32 @@ type InspectionWorkloadStackProps struct {
 	awscdk.StackProps
 	cidr        string
 	transitGWId *string
+	// Defaults to two AZs.
+	azs VpcAzConfig
+	// Subnet mask of the private subnets, defaults to /24.
+	subnetMask float64
+	// Use the interface endpoints of the shared-services VPC instead of
+	// creating them in the spoke.
+	centralizedEndpoints bool
+	// DNS Firewall rule group associated with the VPC, none when nil.
+	dnsFirewallRuleGroupId *string
+	// Resources deployed into the VPC, networking only when nil.
+	workload WorkloadTemplate
+	// Attach the VPC through dedicated /28 subnets with their own route
+	// tables and network ACL instead of the workload subnets.
+	dedicatedAttachmentSubnets bool
+	// Dual-stack VPC, IPv4 only when nil.
+	dualStack *Ipv6Config
 }
 
-func InspectionWorkloadStack(scope constructs.Construct, id string, props *InspectionWorkloadStackProps) {
+type InspectionWorkloadStackOutputs struct {
+	awscdk.Stack
+	account           string
+	vpcId             *string
+	availabilityZones *[]*string
+}
+
+func InspectionWorkloadStack(scope constructs.Construct, id string, props *InspectionWorkloadStackProps) InspectionWorkloadStackOutputs {
 
 	var sprops awscdk.StackProps
 	if props != nil {
@@ -41,7 +64,7 @@ func InspectionWorkloadStack(scope constructs.Construct, id string, props *Inspe
 
 	stack := awscdk.NewStack(scope, &id, &sprops)
 
-	vpc := ec2.NewVpc(stack, jsii.String("vpc"), &ec2.VpcProps{
+	vpcProps := &ec2.VpcProps{
 		MaxAzs:             jsii.Number(2),
 		IpAddresses:        ec2.IpAddresses_Cidr(&props.cidr),
 		EnableDnsSupport:   jsii.Bool(true),
@@ -50,15 +73,31 @@ func InspectionWorkloadStack(scope constructs.Construct, id string, props *Inspe
 			{
 				Name:       jsii.String("Private"),
 				SubnetType: ec2.SubnetType_PRIVATE_ISOLATED,
-				CidrMask:   jsii.Number(24),
+				CidrMask:   subnetMask(props.subnetMask, 24),
 			},
 		},
-	})
+	}
+	attachmentSubnetGroup := "Private"
+	if props.dedicatedAttachmentSubnets {
+		// Appended after the workload subnets so their CIDRs do not move.
+		attachmentSubnetGroup = "TgwAttachment"
+		*vpcProps.SubnetConfiguration = append(*vpcProps.SubnetConfiguration, &ec2.SubnetConfiguration{
+			Name:       jsii.String(attachmentSubnetGroup),
+			SubnetType: ec2.SubnetType_PRIVATE_ISOLATED,
+			CidrMask:   jsii.Number(28),
+		})
+	}
+	applyAzConfig(vpcProps, props.azs, stackAccount(stack))
+
+	vpc := ec2.NewVpc(stack, jsii.String("vpc"), vpcProps)
+	if props.dualStack != nil {
+		enableDualStack(stack, vpc, *props.dualStack)
+	}
 
 	awscdk.NewCfnOutput(stack, jsii.String("vpc_id"), &awscdk.CfnOutputProps{Value: vpc.VpcId()})
 
 	privateSubs := vpc.SelectSubnetObjects(&ec2.SubnetSelection{
-		SubnetGroupName: jsii.String("Private"),
+		SubnetGroupName: jsii.String(attachmentSubnetGroup),
 	})
 	var privateSubsIds []*string
 
@@ -67,10 +106,16 @@ func InspectionWorkloadStack(scope constructs.Construct, id string, props *Inspe
 		privateSubsIds = append(privateSubsIds, privateSubId)
 	}
 
+	var attachmentOptions interface{}
+	if props.dualStack != nil {
+		attachmentOptions = map[string]string{"Ipv6Support": "enable"}
+	}
+
 	tGWAttachment := ec2.NewCfnTransitGatewayAttachment(stack, jsii.String("TGW_Attachment"), &ec2.CfnTransitGatewayAttachmentProps{
 		TransitGatewayId: props.transitGWId,
 		SubnetIds:        &privateSubsIds,
 		VpcId:            vpc.VpcId(),
+		Options:          attachmentOptions,
 		Tags: &[]*awscdk.CfnTag{
 			{
 				Key:   jsii.String("routeTable"),
@@ -79,10 +124,33 @@ func InspectionWorkloadStack(scope constructs.Construct, id string, props *Inspe
 		},
 	})
 
+	if props.dedicatedAttachmentSubnets {
+		// Filtering stays in the security groups of the workloads, the
+		// attachment subnets allow all traffic as AWS recommends.
+		attachmentAcl := ec2.NewNetworkAcl(stack, jsii.String("TgwAttachmentAcl"), &ec2.NetworkAclProps{
+			Vpc:             vpc,
+			SubnetSelection: &ec2.SubnetSelection{SubnetGroupName: jsii.String(attachmentSubnetGroup)},
+		})
+		for _, direction := range []ec2.TrafficDirection{ec2.TrafficDirection_INGRESS, ec2.TrafficDirection_EGRESS} {
+			attachmentAcl.AddEntry(jsii.String(fmt.Sprintf("AllowAll-%s", direction)), &ec2.CommonNetworkAclEntryOptions{
+				Cidr:       ec2.AclCidr_AnyIpv4(),
+				RuleNumber: jsii.Number(100),
+				Traffic:    ec2.AclTraffic_AllTraffic(),
+				Direction:  direction,
+				RuleAction: ec2.Action_ALLOW,
+			})
+		}
+	}
+
 	subs := vpc.SelectSubnetObjects(&ec2.SubnetSelection{
 		SubnetGroupName: jsii.String("Private"),
 	})
 
+	var ipv6Destination *string
+	if props.dualStack != nil {
+		ipv6Destination = spokeIpv6Destination(stack, *props.dualStack)
+	}
+
 	// Create a custom resource for each TGw subnet
 	for _, subnet := range *subs {
 		ec2.NewCfnRoute(stack, jsii.String(fmt.Sprintf("Default-route-%s", strings.SplitN(*subnet.Node().Path(), "/", 1))), &ec2.CfnRouteProps{
@@ -90,33 +158,40 @@ func InspectionWorkloadStack(scope constructs.Construct, id string, props *Inspe
 			DestinationCidrBlock: jsii.String("0.0.0.0/0"),
 			TransitGatewayId:     props.transitGWId,
 		}).AddDependency(tGWAttachment)
+		if props.dualStack != nil {
+			ec2.NewCfnRoute(stack, jsii.String(fmt.Sprintf("Ipv6-default-route-%s", strings.SplitN(*subnet.Node().Path(), "/", 1))), &ec2.CfnRouteProps{
+				RouteTableId:             subnet.RouteTable().RouteTableId(),
+				DestinationIpv6CidrBlock: ipv6Destination,
+				TransitGatewayId:         props.transitGWId,
+			}).AddDependency(tGWAttachment)
+		}
 	}
 
-	vpc.AddInterfaceEndpoint(jsii.String("SSMEndpoint"), &ec2.InterfaceVpcEndpointOptions{Service: ec2.InterfaceVpcEndpointAwsService_SSM()})
-	vpc.AddInterfaceEndpoint(jsii.String("SSMMessagesEndpoint"), &ec2.InterfaceVpcEndpointOptions{Service: ec2.InterfaceVpcEndpointAwsService_SSM_MESSAGES()})
-	vpc.AddInterfaceEndpoint(jsii.String("Ec2MessagesEndpoint"), &ec2.InterfaceVpcEndpointOptions{Service: ec2.InterfaceVpcEndpointAwsService_EC2_MESSAGES()})
+	if !props.centralizedEndpoints {
+		endpointSubnets := &ec2.SubnetSelection{SubnetGroupName: jsii.String("Private")}
+		vpc.AddInterfaceEndpoint(jsii.String("SSMEndpoint"), &ec2.InterfaceVpcEndpointOptions{Service: ec2.InterfaceVpcEndpointAwsService_SSM(), Subnets: endpointSubnets})
+		vpc.AddInterfaceEndpoint(jsii.String("SSMMessagesEndpoint"), &ec2.InterfaceVpcEndpointOptions{Service: ec2.InterfaceVpcEndpointAwsService_SSM_MESSAGES(), Subnets: endpointSubnets})
+		vpc.AddInterfaceEndpoint(jsii.String("Ec2MessagesEndpoint"), &ec2.InterfaceVpcEndpointOptions{Service: ec2.InterfaceVpcEndpointAwsService_EC2_MESSAGES(), Subnets: endpointSubnets})
+	}
 
-	SSMRole := iam.NewRole(stack, jsii.String("SSMRole"), &iam.RoleProps{
-		AssumedBy:       iam.NewServicePrincipal(jsii.String("ec2.amazonaws.com"), nil),
-		ManagedPolicies: &[]iam.IManagedPolicy{iam.ManagedPolicy_FromAwsManagedPolicyName(jsii.String("AmazonSSMManagedInstanceCore"))},
-	})
+	if props.dnsFirewallRuleGroupId != nil {
+		resolver.NewCfnFirewallRuleGroupAssociation(stack, jsii.String("DnsFirewallAssociation"), &resolver.CfnFirewallRuleGroupAssociationProps{
+			FirewallRuleGroupId: props.dnsFirewallRuleGroupId,
+			VpcId:               vpc.VpcId(),
+			Priority:            jsii.Number(101),
+			Name:                jsii.String(fmt.Sprintf("%s-dns-firewall", *stack.StackName())),
+		})
+	}
 
-	securityGroup := ec2.NewSecurityGroup(stack, jsii.String("WorkloadEC2SG"), &ec2.SecurityGroupProps{
-		SecurityGroupName: jsii.String("workload-sg"),
-		Vpc:               vpc,
-	})
+	if props.workload != nil {
+		props.workload.Deploy(stack, vpc)
+	}
 
-	securityGroup.AddIngressRule(ec2.Peer_AnyIpv4(), ec2.Port_AllIcmp(), jsii.String("WorkloadIngressRule"), jsii.Bool(true))
-
-	ec2.NewInstance(stack, jsii.String("WorkloadEC2"), &ec2.InstanceProps{
-		Vpc:          vpc,
-		VpcSubnets:   &ec2.SubnetSelection{SubnetType: ec2.SubnetType_PRIVATE_ISOLATED},
-		InstanceType: ec2.InstanceType_Of(ec2.InstanceClass_BURSTABLE4_GRAVITON, ec2.InstanceSize_MICRO),
-		MachineImage: ec2.MachineImage_LatestAmazonLinux(&ec2.AmazonLinuxImageProps{
-			CpuType:    ec2.AmazonLinuxCpuType_ARM_64,
-			Generation: ec2.AmazonLinuxGeneration_AMAZON_LINUX_2,
-		}),
-		Role:          SSMRole,
-		SecurityGroup: securityGroup,
-	})
+	var outputs InspectionWorkloadStackOutputs
+	outputs.Stack = stack
+	outputs.account = stackAccount(stack)
+	outputs.vpcId = vpc.VpcId()
+	outputs.availabilityZones = vpc.AvailabilityZones()
+
+	return outputs
 }
@@ -0,0 +1,148 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cdkPipelines
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	ec2 "github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
+	firewall "github.com/aws/aws-cdk-go/awscdk/v2/awsnetworkfirewall"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// Adds an IPv6 block to the VPC and a /64 of it to every subnet. CDK 2.67
+// has no dual-stack VPC, so the generated subnets are patched.
+func enableDualStack(stack awscdk.Stack, vpc ec2.Vpc, config Ipv6Config) {
+	cidrBlockProps := &ec2.CfnVPCCidrBlockProps{VpcId: vpc.VpcId()}
+	if config.ipamPoolId == "" {
+		cidrBlockProps.AmazonProvidedIpv6CidrBlock = jsii.Bool(true)
+	} else {
+		cidrBlockProps.Ipv6IpamPoolId = jsii.String(config.ipamPoolId)
+		cidrBlockProps.Ipv6NetmaskLength = subnetMask(config.netmaskLength, 56)
+	}
+	cidrBlock := ec2.NewCfnVPCCidrBlock(stack, jsii.String("Ipv6CidrBlock"), cidrBlockProps)
+
+	var subnets []ec2.ISubnet
+	subnets = append(subnets, *vpc.PublicSubnets()...)
+	subnets = append(subnets, *vpc.PrivateSubnets()...)
+	subnets = append(subnets, *vpc.IsolatedSubnets()...)
+
+	vpcIpv6Cidr := awscdk.Fn_Select(jsii.Number(0), vpc.VpcIpv6CidrBlocks())
+	subnetCidrs := awscdk.Fn_Cidr(vpcIpv6Cidr, jsii.Number(float64(len(subnets))), jsii.String("64"))
+	for i, subnet := range subnets {
+		cfnSubnet := subnet.Node().DefaultChild().(ec2.CfnSubnet)
+		cfnSubnet.SetIpv6CidrBlock(awscdk.Fn_Select(jsii.Number(float64(i)), subnetCidrs))
+		cfnSubnet.SetAssignIpv6AddressOnCreation(jsii.Bool(true))
+		cfnSubnet.AddDependency(cidrBlock)
+	}
+
+	for _, subnet := range *vpc.PublicSubnets() {
+		ec2.NewCfnRoute(stack, jsii.String(fmt.Sprintf("Ipv6DefaultRoute-%s", *subnet.Node().Id())), &ec2.CfnRouteProps{
+			RouteTableId:             subnet.RouteTable().RouteTableId(),
+			DestinationIpv6CidrBlock: jsii.String("::/0"),
+			GatewayId:                vpc.InternetGatewayId(),
+		})
+	}
+}
+
+// IPv6 destination the spokes route to the transit gateway. The hub has no
+// IPv6 internet egress for the spokes, the egress-only internet gateway of
+// the inspection VPC drops their traffic, so only the organization is routed
+// when it is known. Without organizationCidr the spokes route ::/0 and IPv6
+// internet traffic is dropped in the hub.
+func spokeIpv6Destination(stack awscdk.Stack, config Ipv6Config) *string {
+	if config.organizationCidr != "" {
+		return jsii.String(config.organizationCidr)
+	}
+	awscdk.Annotations_Of(stack).AddWarning(jsii.String("DualStack has no organizationCidr, the spokes route ::/0 to the transit gateway and their IPv6 internet traffic is dropped in the hub"))
+	return jsii.String("::/0")
+}
+
+// IPv6 sources of the organization in firewall rules.
+func ipv6RuleSource() string {
+	if DualStack.organizationCidr == "" {
+		return "::/0"
+	}
+	return DualStack.organizationCidr
+}
+
+// Stateful rules mirroring the IPv4 allow rules for IPv6 sources, numbered
+// from firstSid, when DualStack is configured.
+func ipv6AllowRules(firstSid int) []interface{} {
+	if DualStack == nil {
+		return nil
+	}
+	var rules []interface{}
+	for i, port := range []struct{ protocol, port string }{{"TCP", "80"}, {"TCP", "443"}, {"UDP", "123"}} {
+		rules = append(rules, &firewall.CfnRuleGroup_StatefulRuleProperty{
+			Action: jsii.String("PASS"),
+			Header: &firewall.CfnRuleGroup_HeaderProperty{
+				Destination:     jsii.String("ANY"),
+				DestinationPort: jsii.String(port.port),
+				Source:          jsii.String(ipv6RuleSource()),
+				SourcePort:      jsii.String("ANY"),
+				Protocol:        jsii.String(port.protocol),
+				Direction:       jsii.String("FORWARD"),
+			},
+			RuleOptions: []interface{}{
+				&firewall.CfnRuleGroup_RuleOptionProperty{
+					Keyword: jsii.String(fmt.Sprintf("sid:%d", firstSid+i)),
+				},
+			},
+		})
+	}
+	return rules
+}
+
+// IPv6 routes of the firewall subnets. Inspected traffic to the organization
+// goes back to the transit gateway, the rest leaves through an egress-only
+// internet gateway. An egress-only gateway only returns traffic to addresses
+// of its own VPC, so spokes need NAT66 for IPv6 internet access through the
+// hub.
+func createIpv6FirewallSubnetRoutes(stack awscdk.Stack, vpc ec2.Vpc, transitGWId *string, tGWAttachment ec2.CfnTransitGatewayAttachment, config Ipv6Config) {
+	fwSubs := vpc.SelectSubnetObjects(&ec2.SubnetSelection{
+		SubnetGroupName: jsii.String("Firewall_Subnet"),
+	})
+
+	if config.organizationCidr == "" {
+		awscdk.Annotations_Of(stack).AddWarning(jsii.String("DualStack has no organizationCidr, IPv6 traffic from the firewall is sent back to the transit gateway and there is no IPv6 egress"))
+		for _, subnet := range *fwSubs {
+			ec2.NewCfnRoute(stack, jsii.String(fmt.Sprintf("OrganisationRouteIpv6-%s", *subnet.Node().Id())), &ec2.CfnRouteProps{
+				RouteTableId:             subnet.RouteTable().RouteTableId(),
+				DestinationIpv6CidrBlock: jsii.String("::/0"),
+				TransitGatewayId:         transitGWId,
+			}).AddDependency(tGWAttachment)
+		}
+		return
+	}
+
+	egressOnlyGateway := ec2.NewCfnEgressOnlyInternetGateway(stack, jsii.String("EgressOnlyInternetGateway"), &ec2.CfnEgressOnlyInternetGatewayProps{
+		VpcId: vpc.VpcId(),
+	})
+	for _, subnet := range *fwSubs {
+		ec2.NewCfnRoute(stack, jsii.String(fmt.Sprintf("OrganisationRouteIpv6-%s", *subnet.Node().Id())), &ec2.CfnRouteProps{
+			RouteTableId:             subnet.RouteTable().RouteTableId(),
+			DestinationIpv6CidrBlock: jsii.String(config.organizationCidr),
+			TransitGatewayId:         transitGWId,
+		}).AddDependency(tGWAttachment)
+		ec2.NewCfnRoute(stack, jsii.String(fmt.Sprintf("EgressRouteIpv6-%s", *subnet.Node().Id())), &ec2.CfnRouteProps{
+			RouteTableId:                subnet.RouteTable().RouteTableId(),
+			DestinationIpv6CidrBlock:    jsii.String("::/0"),
+			EgressOnlyInternetGatewayId: egressOnlyGateway.Ref(),
+		})
+	}
+}
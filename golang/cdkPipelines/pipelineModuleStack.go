@@ -16,6 +16,8 @@
 package cdkPipelines
 
 import (
+	"fmt"
+
 	"github.com/aws/aws-cdk-go/awscdk/v2"
 	codecommit "github.com/aws/aws-cdk-go/awscdk/v2/awscodecommit"
 	"github.com/aws/aws-cdk-go/awscdk/v2/pipelines"
@@ -23,6 +25,8 @@ import (
 	"github.com/aws/jsii-runtime-go"
 )
 
+const firewallRulesOnlyContext = "firewallRulesOnly"
+
 type PipelineStackProps struct {
 	awscdk.StackProps
 }
@@ -38,27 +42,68 @@ func NetworkPipelineStack(scope constructs.Construct, id string, props *Pipeline
 		RepositoryName: jsii.String("network-pipeline-repo"),
 	})
 
-	pipeline := pipelines.NewCodePipeline(stack, jsii.String("cdkpipeline"), &pipelines.CodePipelineProps{
-		PipelineName: jsii.String("WorkshopPipeline"),
+	// The fast-path pipeline synthesizes the app with this context set. Only
+	// its own rules stage is built then, so the branch never needs the VPC
+	// lookups or other config of the main pipeline.
+	rulesOnly := fmt.Sprint(stack.Node().TryGetContext(jsii.String(firewallRulesOnlyContext))) == "true"
+
+	if !rulesOnly {
+		pipeline := pipelines.NewCodePipeline(stack, jsii.String("cdkpipeline"), &pipelines.CodePipelineProps{
+			PipelineName: jsii.String("WorkshopPipeline"),
+			Synth: pipelines.NewShellStep(jsii.String("build-and-synth"), &pipelines.ShellStepProps{
+				Input: pipelines.CodePipelineSource_CodeCommit(sourceRepo, jsii.String("main"), nil),
+				Commands: jsii.Strings(
+					"npm install -g aws-cdk",
+					"goenv install 1.24.5",
+					"goenv local 1.24.5",
+					"npx cdk synth",
+				),
+			}),
+		})
+
+		deployFirewallRules := FirewallRulesStage(
+			stack, "DeployFirewallRules", &FirewallRulesStageProps{
+				awscdk.StageProps{
+					Env: &HubEnv,
+				},
+			})
+
+		pipeline.AddStage(deployFirewallRules, nil)
+
+		deployFirewallStack := NetworkWorkshopInspectStage(
+			stack, "DeployInspection", &NetworkWorkshopInspectStageProps{
+				awscdk.StageProps{
+					Env: &HubEnv,
+				},
+			})
+
+		pipeline.AddStage(deployFirewallStack, nil)
+	}
+
+	// Fast-path pipeline that only updates the firewall rules, so urgent
+	// blocks can go out without redeploying the VPC and firewall stacks.
+	// The main pipeline deploys the rules of main again on its next run, so
+	// the branch has to be merged back to main right after.
+	rulesPipeline := pipelines.NewCodePipeline(stack, jsii.String("cdkrulespipeline"), &pipelines.CodePipelineProps{
+		PipelineName: jsii.String("FirewallRulesPipeline"),
+		SelfMutation: jsii.Bool(false),
 		Synth: pipelines.NewShellStep(jsii.String("build-and-synth"), &pipelines.ShellStepProps{
-			Input: pipelines.CodePipelineSource_CodeCommit(sourceRepo, jsii.String("main"), nil),
+			Input: pipelines.CodePipelineSource_CodeCommit(sourceRepo, jsii.String(FirewallRulesBranch), nil),
 			Commands: jsii.Strings(
 				"npm install -g aws-cdk",
-				"goenv install 1.18.3",
-				"goenv local 1.18.3",
-				"npx cdk synth",
+				"goenv install 1.24.5",
+				"goenv local 1.24.5",
+				fmt.Sprintf("npx cdk synth -c %s=true", firewallRulesOnlyContext),
 			),
 		}),
 	})
 
-	deployFirewallStack := NetworkWorkshopInspectStage(
-		stack, "DeployInspection", &NetworkWorkshopInspectStageProps{
+	rulesPipeline.AddStage(FirewallRulesStage(
+		stack, "DeployFirewallRulesFastPath", &FirewallRulesStageProps{
 			awscdk.StageProps{
 				Env: &HubEnv,
 			},
-		})
-
-	pipeline.AddStage(deployFirewallStack, nil)
+		}), nil)
 
 	return stack
 }
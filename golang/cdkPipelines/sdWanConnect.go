@@ -0,0 +1,167 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cdkPipelines
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	ec2 "github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// Creates a transport VPC for the SD-WAN appliances and a Connect attachment
+// on top of its VPC attachment, with a GRE/BGP Connect peer per appliance.
+// The Connect attachment is associated with the SD-WAN segment, so the
+// branch routes learned over BGP are propagated into the inspection route
+// table and branch traffic to the organization goes through the firewall.
+func createSdWanConnect(stack awscdk.Stack, transitGWId *string, config SdWanConfig, routeTables map[string]*string, transitGatewayCidrBlocks []string) {
+	if len(transitGatewayCidrBlocks) == 0 {
+		panic("SD-WAN Connect peers need transit gateway cidrBlocks")
+	}
+
+	vpc := ec2.NewVpc(stack, jsii.String("SdWanTransportVpc"), &ec2.VpcProps{
+		MaxAzs:             jsii.Number(2),
+		IpAddresses:        ec2.IpAddresses_Cidr(jsii.String(config.transportVpcCidr)),
+		EnableDnsSupport:   jsii.Bool(true),
+		EnableDnsHostnames: jsii.Bool(true),
+		NatGateways:        jsii.Number(0),
+		// The appliances reach the branches over the internet.
+		SubnetConfiguration: &[]*ec2.SubnetConfiguration{
+			{
+				Name:       jsii.String("Appliance"),
+				SubnetType: ec2.SubnetType_PUBLIC,
+				CidrMask:   jsii.Number(28),
+			},
+		},
+	})
+
+	applianceSubnets := vpc.SelectSubnetObjects(&ec2.SubnetSelection{
+		SubnetGroupName: jsii.String("Appliance"),
+	})
+	var applianceSubnetIds []*string
+	for _, subnet := range *applianceSubnets {
+		applianceSubnetIds = append(applianceSubnetIds, subnet.SubnetId())
+	}
+
+	// The transport attachment is associated here rather than by the
+	// attachment handler, which could miss its create event and would then
+	// leave the Connect attachment without transport. The tag keeps the
+	// handler from touching it.
+	transportAttachment := ec2.NewCfnTransitGatewayAttachment(stack, jsii.String("SdWanTransportAttachment"), &ec2.CfnTransitGatewayAttachmentProps{
+		TransitGatewayId: transitGWId,
+		SubnetIds:        &applianceSubnetIds,
+		VpcId:            vpc.VpcId(),
+		Tags: &[]*awscdk.CfnTag{
+			{
+				Key:   jsii.String("routeTable"),
+				Value: jsii.String(SdWanSegment),
+			},
+			{
+				Key:   jsii.String("associationManagedBy"),
+				Value: jsii.String("cloudformation"),
+			},
+		},
+	})
+
+	ec2.NewCfnTransitGatewayRouteTableAssociation(stack, jsii.String("SdWanTransportAssociation"), &ec2.CfnTransitGatewayRouteTableAssociationProps{
+		TransitGatewayAttachmentId: transportAttachment.AttrId(),
+		TransitGatewayRouteTableId: routeTables[SdWanSegment],
+	})
+
+	for _, target := range resolvedSegment(SdWanSegment).propagateTo {
+		ec2.NewCfnTransitGatewayRouteTablePropagation(stack, jsii.String("SdWanTransportPropagation-"+target), &ec2.CfnTransitGatewayRouteTablePropagationProps{
+			TransitGatewayAttachmentId: transportAttachment.AttrId(),
+			TransitGatewayRouteTableId: routeTables[target],
+		})
+	}
+
+	// GRE tunnels terminate on the transit gateway CIDR blocks.
+	for _, subnet := range *applianceSubnets {
+		for _, cidr := range transitGatewayCidrBlocks {
+			ec2.NewCfnRoute(stack, jsii.String(fmt.Sprintf("SdWan-GRE-route-%s-%s", *subnet.Node().Id(), strings.ReplaceAll(cidr, "/", "-"))), &ec2.CfnRouteProps{
+				RouteTableId:         subnet.RouteTable().RouteTableId(),
+				DestinationCidrBlock: jsii.String(cidr),
+				TransitGatewayId:     transitGWId,
+			}).AddDependency(transportAttachment)
+		}
+	}
+
+	connect := ec2.NewCfnTransitGatewayConnect(stack, jsii.String("SdWanConnect"), &ec2.CfnTransitGatewayConnectProps{
+		TransportTransitGatewayAttachmentId: transportAttachment.AttrId(),
+		Options: &ec2.CfnTransitGatewayConnect_TransitGatewayConnectOptionsProperty{
+			Protocol: jsii.String("gre"),
+		},
+		Tags: &[]*awscdk.CfnTag{
+			{
+				Key:   jsii.String("Name"),
+				Value: jsii.String("sd-wan"),
+			},
+		},
+	})
+
+	ec2.NewCfnTransitGatewayRouteTableAssociation(stack, jsii.String("SdWanConnectAssociation"), &ec2.CfnTransitGatewayRouteTableAssociationProps{
+		TransitGatewayAttachmentId: connect.AttrTransitGatewayAttachmentId(),
+		TransitGatewayRouteTableId: routeTables[SdWanSegment],
+	})
+
+	for _, target := range resolvedSegment(SdWanSegment).propagateTo {
+		ec2.NewCfnTransitGatewayRouteTablePropagation(stack, jsii.String("SdWanConnectPropagation-"+target), &ec2.CfnTransitGatewayRouteTablePropagationProps{
+			TransitGatewayAttachmentId: connect.AttrTransitGatewayAttachmentId(),
+			TransitGatewayRouteTableId: routeTables[target],
+		})
+	}
+
+	for _, peer := range config.peers {
+		peerConfiguration := map[string]interface{}{
+			"PeerAddress":      peer.peerAddress,
+			"InsideCidrBlocks": peer.insideCidrBlocks,
+			"BgpConfigurations": []map[string]interface{}{
+				{"PeerAsn": peer.peerAsn},
+			},
+		}
+		if peer.transitGatewayAddress != "" {
+			peerConfiguration["TransitGatewayAddress"] = peer.transitGatewayAddress
+		}
+
+		// The CDK version in use has no L1 construct for Connect peers.
+		connectPeer := awscdk.NewCfnResource(stack, jsii.String(fmt.Sprintf("SdWanConnectPeer-%s", peer.name)), &awscdk.CfnResourceProps{
+			Type: jsii.String("AWS::EC2::TransitGatewayConnectPeer"),
+			Properties: &map[string]interface{}{
+				"TransitGatewayAttachmentId": connect.AttrTransitGatewayAttachmentId(),
+				"ConnectPeerConfiguration":   peerConfiguration,
+				"Tags": []map[string]string{
+					{"Key": "Name", "Value": peer.name},
+				},
+			},
+		})
+
+		awscdk.NewCfnOutput(stack, jsii.String(fmt.Sprintf("sdwan-%s-peer-output", peer.name)), &awscdk.CfnOutputProps{
+			Value:       connectPeer.Ref(),
+			Description: jsii.String(fmt.Sprintf("Connect peer of SD-WAN appliance %s", peer.name)),
+		})
+	}
+
+	awscdk.NewCfnOutput(stack, jsii.String("sdwan-transport-vpc-output"), &awscdk.CfnOutputProps{
+		Value:       vpc.VpcId(),
+		Description: jsii.String("Transport VPC of the SD-WAN appliances"),
+	})
+	awscdk.NewCfnOutput(stack, jsii.String("sdwan-connect-attachment-output"), &awscdk.CfnOutputProps{
+		Value:       connect.AttrTransitGatewayAttachmentId(),
+		Description: jsii.String("Connect attachment of the SD-WAN appliances"),
+	})
+}
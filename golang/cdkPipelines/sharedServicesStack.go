@@ -0,0 +1,197 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cdkPipelines
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	ec2 "github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
+	route53 "github.com/aws/aws-cdk-go/awscdk/v2/awsroute53"
+	targets "github.com/aws/aws-cdk-go/awscdk/v2/awsroute53targets"
+	"github.com/aws/constructs-go/constructs/v10"
+	"github.com/aws/jsii-runtime-go"
+)
+
+type SharedServicesStackProps struct {
+	awscdk.StackProps
+	cidr        string
+	transitGWId *string
+	// Interface endpoint services, e.g. "ssm".
+	endpointServices []string
+	// Spokes resolving the endpoints through the private hosted zones. They
+	// must be in the same account.
+	spokes []InspectionWorkloadStackOutputs
+	// Resolver endpoints and forwarding rules, none when nil.
+	hybridDns *HybridDnsConfig
+}
+
+type SharedServicesStackOutputs struct {
+	awscdk.Stack
+	vpc ec2.Vpc
+}
+
+// Private DNS names of the supported interface endpoint services, without the
+// <region>.amazonaws.com suffix. A leading "*." also covers the subdomains,
+// e.g. the bucket names of s3.
+var endpointPrivateDnsNames = map[string]string{
+	"ec2":            "ec2",
+	"ec2messages":    "ec2messages",
+	"ecr.api":        "api.ecr",
+	"ecr.dkr":        "*.dkr.ecr",
+	"execute-api":    "*.execute-api",
+	"kms":            "kms",
+	"logs":           "logs",
+	"monitoring":     "monitoring",
+	"s3":             "*.s3",
+	"secretsmanager": "secretsmanager",
+	"sns":            "sns",
+	"sqs":            "sqs",
+	"ssm":            "ssm",
+	"ssmmessages":    "ssmmessages",
+	"sts":            "sts",
+}
+
+// Private hosted zone of an endpoint service and whether it needs a wildcard
+// record next to the apex record.
+func endpointPrivateDns(service string) (zoneName string, wildcard bool) {
+	name, ok := endpointPrivateDnsNames[service]
+	if !ok {
+		var supported []string
+		for service := range endpointPrivateDnsNames {
+			supported = append(supported, service)
+		}
+		slices.Sort(supported)
+		panic(fmt.Sprintf("endpoint service %q has no known private DNS name, supported services are %s", service, strings.Join(supported, ", ")))
+	}
+	return strings.TrimPrefix(name, "*."), strings.HasPrefix(name, "*.")
+}
+
+// Shared-services VPC hosting the interface endpoints of all spokes. Each
+// endpoint has its private DNS disabled and a private hosted zone for the
+// private DNS name of the service instead, associated with the spokes, so
+// spokes resolve the service to the endpoint and reach it through the
+// transit gateway.
+func SharedServicesStack(scope constructs.Construct, id string, props *SharedServicesStackProps) SharedServicesStackOutputs {
+	var sprops awscdk.StackProps
+	if props != nil {
+		sprops = props.StackProps
+	}
+
+	for _, service := range props.endpointServices {
+		endpointPrivateDns(service)
+	}
+
+	stack := awscdk.NewStack(scope, &id, &sprops)
+
+	vpc := ec2.NewVpc(stack, jsii.String("vpc"), &ec2.VpcProps{
+		MaxAzs:             jsii.Number(2),
+		IpAddresses:        ec2.IpAddresses_Cidr(&props.cidr),
+		EnableDnsSupport:   jsii.Bool(true),
+		EnableDnsHostnames: jsii.Bool(true),
+		SubnetConfiguration: &[]*ec2.SubnetConfiguration{
+			{
+				Name:       jsii.String("Endpoints"),
+				SubnetType: ec2.SubnetType_PRIVATE_ISOLATED,
+				CidrMask:   jsii.Number(26),
+			},
+		},
+	})
+
+	awscdk.NewCfnOutput(stack, jsii.String("vpc_id"), &awscdk.CfnOutputProps{Value: vpc.VpcId()})
+
+	endpointSubnets := vpc.SelectSubnetObjects(&ec2.SubnetSelection{
+		SubnetGroupName: jsii.String("Endpoints"),
+	})
+	var endpointSubnetIds []*string
+	for _, subnet := range *endpointSubnets {
+		endpointSubnetIds = append(endpointSubnetIds, subnet.SubnetId())
+	}
+
+	tGWAttachment := ec2.NewCfnTransitGatewayAttachment(stack, jsii.String("TGW_Attachment"), &ec2.CfnTransitGatewayAttachmentProps{
+		TransitGatewayId: props.transitGWId,
+		SubnetIds:        &endpointSubnetIds,
+		VpcId:            vpc.VpcId(),
+		Tags: &[]*awscdk.CfnTag{
+			{
+				Key:   jsii.String("routeTable"),
+				Value: jsii.String("shared-services"),
+			},
+		},
+	})
+
+	for _, subnet := range *endpointSubnets {
+		ec2.NewCfnRoute(stack, jsii.String(fmt.Sprintf("Organisation-route-%s", strings.SplitN(*subnet.Node().Path(), "/", 1))), &ec2.CfnRouteProps{
+			RouteTableId:         subnet.RouteTable().RouteTableId(),
+			DestinationCidrBlock: jsii.String(OrganizationCidr),
+			TransitGatewayId:     props.transitGWId,
+		}).AddDependency(tGWAttachment)
+	}
+
+	var spokeVpcs []ec2.IVpc
+	for i, spoke := range props.spokes {
+		spokeVpcs = append(spokeVpcs, ec2.Vpc_FromVpcAttributes(stack, jsii.String(fmt.Sprintf("SpokeVpc%d", i+1)), &ec2.VpcAttributes{
+			VpcId:             spoke.vpcId,
+			AvailabilityZones: spoke.availabilityZones,
+		}))
+	}
+
+	for _, service := range props.endpointServices {
+		zoneName, wildcard := endpointPrivateDns(service)
+		endpoint := vpc.AddInterfaceEndpoint(jsii.String(fmt.Sprintf("Endpoint-%s", service)), &ec2.InterfaceVpcEndpointOptions{
+			Service:           ec2.NewInterfaceVpcEndpointAwsService(jsii.String(service), nil, nil),
+			PrivateDnsEnabled: jsii.Bool(false),
+			Subnets:           &ec2.SubnetSelection{SubnetGroupName: jsii.String("Endpoints")},
+		})
+		endpoint.Connections().AllowFrom(ec2.Peer_Ipv4(jsii.String(OrganizationCidr)), ec2.Port_Tcp(jsii.Number(443)), jsii.String("HTTPS from the organization"))
+
+		zone := route53.NewPrivateHostedZone(stack, jsii.String(fmt.Sprintf("Zone-%s", service)), &route53.PrivateHostedZoneProps{
+			ZoneName: jsii.String(fmt.Sprintf("%s.%s.amazonaws.com", zoneName, *stack.Region())),
+			Vpc:      vpc,
+		})
+		for _, spokeVpc := range spokeVpcs {
+			zone.AddVpc(spokeVpc)
+		}
+
+		route53.NewARecord(stack, jsii.String(fmt.Sprintf("Record-%s", service)), &route53.ARecordProps{
+			Zone:   zone,
+			Target: route53.RecordTarget_FromAlias(targets.NewInterfaceVpcEndpointTarget(endpoint)),
+		})
+		if wildcard {
+			route53.NewARecord(stack, jsii.String(fmt.Sprintf("WildcardRecord-%s", service)), &route53.ARecordProps{
+				Zone:       zone,
+				RecordName: jsii.String("*"),
+				Target:     route53.RecordTarget_FromAlias(targets.NewInterfaceVpcEndpointTarget(endpoint)),
+			})
+		}
+	}
+
+	if props.hybridDns != nil {
+		var spokeVpcIds []*string
+		for _, spoke := range props.spokes {
+			spokeVpcIds = append(spokeVpcIds, spoke.vpcId)
+		}
+		createHybridDns(stack, vpc, endpointSubnetIds, *props.hybridDns, spokeVpcIds)
+	}
+
+	var outputs SharedServicesStackOutputs
+	outputs.Stack = stack
+	outputs.vpc = vpc
+
+	return outputs
+}
@@ -0,0 +1,47 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cdkPipelines
+
+import "testing"
+
+func TestEndpointPrivateDns(t *testing.T) {
+	tests := []struct {
+		service      string
+		wantZone     string
+		wantWildcard bool
+		wantPanic    bool
+	}{
+		{service: "ssm", wantZone: "ssm"},
+		{service: "ecr.api", wantZone: "api.ecr"},
+		{service: "ecr.dkr", wantZone: "dkr.ecr", wantWildcard: true},
+		{service: "s3", wantZone: "s3", wantWildcard: true},
+		{service: "unknown", wantPanic: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.service, func(t *testing.T) {
+			defer func() {
+				if r := recover(); (r != nil) != tt.wantPanic {
+					t.Errorf("endpointPrivateDns panic = %v, want panic %v", r, tt.wantPanic)
+				}
+			}()
+			zone, wildcard := endpointPrivateDns(tt.service)
+			if zone != tt.wantZone || wildcard != tt.wantWildcard {
+				t.Errorf("endpointPrivateDns = %q, %v, want %q, %v", zone, wildcard, tt.wantZone, tt.wantWildcard)
+			}
+		})
+	}
+}
@@ -0,0 +1,280 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cdkPipelines
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsathena"
+	ec2 "github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsglue"
+	iam "github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	logs "github.com/aws/aws-cdk-go/awscdk/v2/awslogs"
+	s3 "github.com/aws/aws-cdk-go/awscdk/v2/awss3"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// Fields of the custom flow log format and their column names and types.
+// The attachment fields let traffic be broken down per attachment.
+var tgwFlowLogFields = []struct {
+	field, column, columnType string
+}{
+	{"version", "version", "int"},
+	{"resource-type", "resource_type", "string"},
+	{"account-id", "account_id", "string"},
+	{"tgw-id", "tgw_id", "string"},
+	{"tgw-attachment-id", "tgw_attachment_id", "string"},
+	{"tgw-pair-attachment-id", "tgw_pair_attachment_id", "string"},
+	{"tgw-src-vpc-id", "tgw_src_vpc_id", "string"},
+	{"tgw-dst-vpc-id", "tgw_dst_vpc_id", "string"},
+	{"srcaddr", "srcaddr", "string"},
+	{"dstaddr", "dstaddr", "string"},
+	{"srcport", "srcport", "int"},
+	{"dstport", "dstport", "int"},
+	{"protocol", "protocol", "int"},
+	{"packets", "packets", "bigint"},
+	{"bytes", "bytes", "bigint"},
+	{"start", "start_time", "bigint"},
+	{"end", "end_time", "bigint"},
+	{"log-status", "log_status", "string"},
+	{"type", "type", "string"},
+	{"packets-lost-no-route", "packets_lost_no_route", "bigint"},
+	{"packets-lost-blackhole", "packets_lost_blackhole", "bigint"},
+	{"flow-direction", "flow_direction", "string"},
+}
+
+// Enables flow logs on the transit gateway, either to CloudWatch Logs with
+// Logs Insights queries, or to S3 with an Athena table and named queries.
+func createTgwFlowLogs(stack awscdk.Stack, transitGWId *string, config TgwFlowLogConfig) {
+	var format []string
+	for _, field := range tgwFlowLogFields {
+		format = append(format, fmt.Sprintf("${%s}", field.field))
+	}
+
+	flowLogProps := &ec2.CfnFlowLogProps{
+		ResourceId:   transitGWId,
+		ResourceType: jsii.String("TransitGateway"),
+		LogFormat:    jsii.String(strings.Join(format, " ")),
+		// Transit gateway flow logs are always aggregated per minute.
+		MaxAggregationInterval: jsii.Number(60),
+	}
+
+	switch config.destinationType {
+	case "cloud-watch-logs":
+		logGroup := logs.NewLogGroup(stack, jsii.String("TgwFlowLogsGroup"), &logs.LogGroupProps{
+			LogGroupName:  jsii.String("TransitGatewayFlowLogs"),
+			RemovalPolicy: awscdk.RemovalPolicy_DESTROY,
+		})
+		flowLogRole := iam.NewRole(stack, jsii.String("tgwFlowLogsRole"), &iam.RoleProps{
+			AssumedBy: iam.NewServicePrincipal(jsii.String("vpc-flow-logs.amazonaws.com"), nil),
+		})
+		logGroup.GrantWrite(flowLogRole)
+		flowLogRole.AddToPolicy(iam.NewPolicyStatement(&iam.PolicyStatementProps{
+			Actions: &[]*string{
+				jsii.String("logs:DescribeLogGroups"),
+				jsii.String("logs:DescribeLogStreams"),
+			},
+			Effect:    iam.Effect_ALLOW,
+			Resources: &[]*string{jsii.String("*")},
+		}))
+
+		flowLogProps.LogDestinationType = jsii.String("cloud-watch-logs")
+		flowLogProps.LogGroupName = logGroup.LogGroupName()
+		flowLogProps.DeliverLogsPermissionArn = flowLogRole.RoleArn()
+		createTgwFlowLogInsightsQueries(stack, logGroup)
+
+	case "s3":
+		bucket := s3.NewBucket(stack, jsii.String("TgwFlowLogsBucket"), &s3.BucketProps{
+			Encryption:        s3.BucketEncryption_S3_MANAGED,
+			BlockPublicAccess: s3.BlockPublicAccess_BLOCK_ALL(),
+			EnforceSSL:        jsii.Bool(true),
+		})
+
+		flowLogProps.LogDestinationType = jsii.String("s3")
+		flowLogProps.LogDestination = bucket.BucketArn()
+		flowLogProps.DestinationOptions = map[string]interface{}{
+			"FileFormat":               "plain-text",
+			"HiveCompatiblePartitions": true,
+			"PerHourPartition":         false,
+		}
+		createTgwFlowLogAthenaTable(stack, bucket)
+
+	default:
+		panic(fmt.Sprintf("unknown transit gateway flow log destination %q", config.destinationType))
+	}
+
+	ec2.NewCfnFlowLog(stack, jsii.String("TgwFlowLog"), flowLogProps)
+}
+
+// Segments with CIDRs and a condition matching their source addresses,
+// built by the given function.
+func segmentConditions(condition func(cidr string) string) [][2]string {
+	var conditions [][2]string
+	for _, segment := range configuredTgwSegments() {
+		if len(segment.cidrs) == 0 {
+			continue
+		}
+		var parts []string
+		for _, cidr := range segment.cidrs {
+			parts = append(parts, condition(cidr))
+		}
+		conditions = append(conditions, [2]string{segment.name, strings.Join(parts, " or ")})
+	}
+	return conditions
+}
+
+func createTgwFlowLogInsightsQueries(stack awscdk.Stack, logGroup logs.LogGroup) {
+	var columns []string
+	for _, field := range tgwFlowLogFields {
+		columns = append(columns, field.column)
+	}
+	parse := fmt.Sprintf("parse @message \"%s\" as %s",
+		strings.TrimSpace(strings.Repeat("* ", len(columns))), strings.Join(columns, ", "))
+
+	queries := [][2]string{
+		{"TopTalkersPerAttachment", strings.Join([]string{
+			parse,
+			"filter log_status = \"OK\"",
+			"stats sum(bytes) as total_bytes by tgw_attachment_id, srcaddr, dstaddr",
+			"sort total_bytes desc",
+			"limit 20",
+		}, "\n| ")},
+		{"BytesPerAttachment", strings.Join([]string{
+			parse,
+			"filter log_status = \"OK\"",
+			"stats sum(bytes) as total_bytes by tgw_attachment_id, flow_direction",
+			"sort total_bytes desc",
+		}, "\n| ")},
+	}
+	for _, segment := range segmentConditions(func(cidr string) string {
+		return fmt.Sprintf("isIpv4InSubnet(srcaddr, \"%s\")", cidr)
+	}) {
+		queries = append(queries, [2]string{"BytesFromSegment-" + segment[0], strings.Join([]string{
+			parse,
+			"filter log_status = \"OK\" and (" + segment[1] + ")",
+			"stats sum(bytes) as total_bytes by bin(1h)",
+		}, "\n| ")})
+	}
+
+	for _, query := range queries {
+		logs.NewCfnQueryDefinition(stack, jsii.String("TgwFlowLogsQuery-"+query[0]), &logs.CfnQueryDefinitionProps{
+			Name:          jsii.String("TransitGateway/" + query[0]),
+			QueryString:   jsii.String(query[1]),
+			LogGroupNames: jsii.Strings(*logGroup.LogGroupName()),
+		})
+	}
+}
+
+func createTgwFlowLogAthenaTable(stack awscdk.Stack, bucket s3.Bucket) {
+	database := awsglue.NewCfnDatabase(stack, jsii.String("TgwFlowLogsDatabase"), &awsglue.CfnDatabaseProps{
+		CatalogId: stack.Account(),
+		DatabaseInput: &awsglue.CfnDatabase_DatabaseInputProperty{
+			Name: jsii.String("tgw_flow_logs"),
+		},
+	})
+
+	var columns []interface{}
+	for _, field := range tgwFlowLogFields {
+		columns = append(columns, &awsglue.CfnTable_ColumnProperty{
+			Name: jsii.String(field.column),
+			Type: jsii.String(field.columnType),
+		})
+	}
+
+	// Partition projection saves loading partitions. The location follows
+	// the Hive compatible prefix written by the flow log.
+	location := fmt.Sprintf("s3://%s/AWSLogs/aws-account-id=%s/aws-service=vpcflowlogs/aws-region=%s/",
+		*bucket.BucketName(), *stack.Account(), *stack.Region())
+	table := awsglue.NewCfnTable(stack, jsii.String("TgwFlowLogsTable"), &awsglue.CfnTableProps{
+		CatalogId:    stack.Account(),
+		DatabaseName: jsii.String("tgw_flow_logs"),
+		TableInput: &awsglue.CfnTable_TableInputProperty{
+			Name:      jsii.String("tgw_flow_logs"),
+			TableType: jsii.String("EXTERNAL_TABLE"),
+			PartitionKeys: []interface{}{
+				&awsglue.CfnTable_ColumnProperty{Name: jsii.String("year"), Type: jsii.String("string")},
+				&awsglue.CfnTable_ColumnProperty{Name: jsii.String("month"), Type: jsii.String("string")},
+				&awsglue.CfnTable_ColumnProperty{Name: jsii.String("day"), Type: jsii.String("string")},
+			},
+			Parameters: map[string]string{
+				"skip.header.line.count":    "1",
+				"projection.enabled":        "true",
+				"projection.year.type":      "integer",
+				"projection.year.range":     "2020,2100",
+				"projection.month.type":     "integer",
+				"projection.month.range":    "1,12",
+				"projection.month.digits":   "2",
+				"projection.day.type":       "integer",
+				"projection.day.range":      "1,31",
+				"projection.day.digits":     "2",
+				"storage.location.template": location + "year=${year}/month=${month}/day=${day}",
+			},
+			StorageDescriptor: &awsglue.CfnTable_StorageDescriptorProperty{
+				Columns:      columns,
+				Location:     jsii.String(location),
+				InputFormat:  jsii.String("org.apache.hadoop.mapred.TextInputFormat"),
+				OutputFormat: jsii.String("org.apache.hadoop.hive.ql.io.HiveIgnoreKeyTextOutputFormat"),
+				SerdeInfo: &awsglue.CfnTable_SerdeInfoProperty{
+					SerializationLibrary: jsii.String("org.apache.hadoop.hive.serde2.lazy.LazySimpleSerDe"),
+					Parameters: map[string]string{
+						"field.delim":          " ",
+						"serialization.format": " ",
+					},
+				},
+			},
+		},
+	})
+	table.AddDependency(database)
+
+	queries := [][2]string{
+		{"TopTalkersPerAttachment", `SELECT tgw_attachment_id, srcaddr, dstaddr, SUM(bytes) AS total_bytes
+FROM tgw_flow_logs.tgw_flow_logs
+WHERE log_status = 'OK' AND from_unixtime(start_time) > current_timestamp - INTERVAL '1' DAY
+GROUP BY tgw_attachment_id, srcaddr, dstaddr
+ORDER BY total_bytes DESC
+LIMIT 20`},
+		{"BytesPerAttachment", `SELECT tgw_attachment_id, flow_direction, SUM(bytes) AS total_bytes
+FROM tgw_flow_logs.tgw_flow_logs
+WHERE log_status = 'OK' AND from_unixtime(start_time) > current_timestamp - INTERVAL '1' DAY
+GROUP BY tgw_attachment_id, flow_direction
+ORDER BY total_bytes DESC`},
+	}
+
+	var cases []string
+	for _, segment := range segmentConditions(func(cidr string) string {
+		return fmt.Sprintf("contains('%s', TRY_CAST(srcaddr AS IPADDRESS))", cidr)
+	}) {
+		cases = append(cases, fmt.Sprintf("WHEN %s THEN '%s'", segment[1], segment[0]))
+	}
+	if len(cases) > 0 {
+		queries = append(queries, [2]string{"BytesPerSegment", fmt.Sprintf(`SELECT CASE %s ELSE 'other' END AS segment, SUM(bytes) AS total_bytes
+FROM tgw_flow_logs.tgw_flow_logs
+WHERE log_status = 'OK' AND from_unixtime(start_time) > current_timestamp - INTERVAL '1' DAY
+GROUP BY 1
+ORDER BY total_bytes DESC`, strings.Join(cases, " "))})
+	}
+
+	for _, query := range queries {
+		namedQuery := awsathena.NewCfnNamedQuery(stack, jsii.String("TgwFlowLogsNamedQuery-"+query[0]), &awsathena.CfnNamedQueryProps{
+			Name:        jsii.String("TransitGateway-" + query[0]),
+			Database:    jsii.String("tgw_flow_logs"),
+			QueryString: jsii.String(query[1]),
+		})
+		namedQuery.AddDependency(table)
+	}
+}
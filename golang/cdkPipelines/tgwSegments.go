@@ -0,0 +1,386 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cdkPipelines
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	ec2 "github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
+	ssm "github.com/aws/aws-cdk-go/awscdk/v2/awsssm"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// Segment mapping as read by the attachment handler.
+type segmentMapping struct {
+	QuarantineRouteTableId string                   `json:"quarantineRouteTableId"`
+	Segments               map[string]segmentTables `json:"segments"`
+	StaticRoutes           []segmentStaticRoute     `json:"staticRoutes"`
+}
+
+type segmentTables struct {
+	AssociationRouteTableId  string   `json:"associationRouteTableId"`
+	PropagationRouteTableIds []string `json:"propagationRouteTableIds"`
+}
+
+// Static routes pointing at the attachment of a segment. The handler
+// creates them once the attachment is associated.
+type segmentStaticRoute struct {
+	RouteTableId    string `json:"routeTableId"`
+	DestinationCidr string `json:"destinationCidr"`
+	TargetSegment   string `json:"targetSegment"`
+}
+
+// Export name of the route table of a segment, e.g. WorkloadRouteTableId or
+// SharedServicesRouteTableId for shared-services.
+func SegmentRouteTableExportName(segment string) string {
+	var name strings.Builder
+	for _, part := range strings.FieldsFunc(segment, func(r rune) bool { return r == '-' || r == '_' }) {
+		name.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return name.String() + "RouteTableId"
+}
+
+// TgwSegments plus the segments of the Direct Connect gateway and the
+// SD-WAN Connect attachment, if configured. Like a workload spoke, their
+// traffic to the organization is routed through the inspection VPC and the
+// inspection route table learns the on-premises routes.
+func configuredTgwSegments() []TgwSegment {
+	segments := append([]TgwSegment{}, TgwSegments...)
+	hybridSegment := func(name string) TgwSegment {
+		return TgwSegment{
+			name:        name,
+			propagateTo: []string{"inspection"},
+			staticRoutes: []TgwStaticRoute{
+				{destinationCidr: OrganizationCidr, targetSegment: "inspection"},
+			},
+		}
+	}
+	if DirectConnect != nil {
+		segments = append(segments, hybridSegment(DirectConnectSegment))
+	}
+	if SdWan != nil {
+		segments = append(segments, hybridSegment(SdWanSegment))
+	}
+	return segments
+}
+
+func segmentRouteTableName(segment string) string {
+	return segment + "-route-table"
+}
+
+func lookupSegment(name string) TgwSegment {
+	for _, segment := range configuredTgwSegments() {
+		if segment.name == name {
+			return segment
+		}
+	}
+	panic(fmt.Sprintf("unknown transit gateway segment %q", name))
+}
+
+// The configured segments with the propagations and static routes generated
+// from TgwConnectivityMatrix added.
+func resolvedTgwSegments() []TgwSegment {
+	configured := configuredTgwSegments()
+	segments := make([]TgwSegment, len(configured))
+	index := map[string]int{}
+	for i, segment := range configured {
+		segment.propagateTo = append([]string{}, segment.propagateTo...)
+		segment.staticRoutes = append([]TgwStaticRoute{}, segment.staticRoutes...)
+		segments[i] = segment
+		index[segment.name] = i
+	}
+
+	propagate := func(from, to string) {
+		segment := &segments[index[from]]
+		for _, existing := range segment.propagateTo {
+			if existing == to {
+				return
+			}
+		}
+		segment.propagateTo = append(segment.propagateTo, to)
+	}
+	// Routes the traffic of a segment towards the CIDRs of another segment.
+	route := func(from, to, targetSegment string) {
+		segment := &segments[index[from]]
+		for _, cidr := range segments[index[to]].cidrs {
+			segment.staticRoutes = append(segment.staticRoutes, TgwStaticRoute{
+				destinationCidr: cidr,
+				targetSegment:   targetSegment,
+			})
+		}
+	}
+
+	for _, connection := range TgwConnectivityMatrix {
+		a, b := connection.segments[0], connection.segments[1]
+		switch connection.connectivity {
+		case TgwDirect:
+			propagate(a, b)
+			propagate(b, a)
+		case TgwInspected:
+			// The firewall sends the traffic back to the transit gateway, so
+			// the inspection route table needs the routes of both segments.
+			propagate(a, "inspection")
+			propagate(b, "inspection")
+			route(a, b, "inspection")
+			if a != b {
+				route(b, a, "inspection")
+			}
+		case TgwIsolated:
+			route(a, b, "")
+			if a != b {
+				route(b, a, "")
+			}
+		}
+	}
+
+	if SpokeIsolation {
+		connected := map[string]bool{}
+		for _, connection := range TgwConnectivityMatrix {
+			a, b := connection.segments[0], connection.segments[1]
+			if a == "workload" {
+				connected[b] = true
+			}
+			if b == "workload" {
+				connected[a] = true
+			}
+		}
+		workload := &segments[index["workload"]]
+		workload.staticRoutes = append(workload.staticRoutes, TgwStaticRoute{
+			destinationCidr: OrganizationCidr,
+			targetSegment:   "inspection",
+		})
+		for _, segment := range segments {
+			if segment.name == "workload" || segment.name == "inspection" || connected[segment.name] {
+				continue
+			}
+			route("workload", segment.name, "")
+		}
+	}
+
+	return segments
+}
+
+// With spoke isolation, attachments of any segment other than inspection
+// may only reach each other, and any other segment, through the inspection
+// VPC. Anything that would put a route to a segment into the route table of
+// another one, or let a segment's route table point past the firewall, is
+// reported as an error.
+func assertSpokeIsolation(stack awscdk.Stack) {
+	if !SpokeIsolation {
+		return
+	}
+	for _, violation := range spokeIsolationViolations(resolvedTgwSegments()) {
+		awscdk.Annotations_Of(stack).AddError(jsii.String("spoke isolation: " + violation))
+	}
+}
+
+func spokeIsolationViolations(segments []TgwSegment) []string {
+	var violations []string
+	for _, segment := range segments {
+		if segment.name == "inspection" {
+			continue
+		}
+		for _, target := range segment.propagateTo {
+			if target != "inspection" {
+				violations = append(violations, fmt.Sprintf(
+					"segment %s propagates into the %s route table, bypassing the firewall", segment.name, target))
+			}
+		}
+		for _, route := range segment.staticRoutes {
+			if route.targetSegment != "" && route.targetSegment != "inspection" {
+				violations = append(violations, fmt.Sprintf(
+					"%s route to %s points at segment %s, bypassing the firewall",
+					segment.name, route.destinationCidr, route.targetSegment))
+			}
+		}
+	}
+	return violations
+}
+
+func resolvedSegment(name string) TgwSegment {
+	for _, segment := range resolvedTgwSegments() {
+		if segment.name == name {
+			return segment
+		}
+	}
+	panic(fmt.Sprintf("unknown transit gateway segment %q", name))
+}
+
+func validateTgwSegments() {
+	seen := map[string]bool{}
+	for _, segment := range configuredTgwSegments() {
+		if seen[segment.name] {
+			panic(fmt.Sprintf("duplicate transit gateway segment %q", segment.name))
+		}
+		seen[segment.name] = true
+	}
+	required := []string{"workload", "inspection"}
+	if SharedServices != nil {
+		required = append(required, "shared-services")
+	}
+	for _, name := range required {
+		lookupSegment(name)
+	}
+	for _, segment := range configuredTgwSegments() {
+		for _, target := range segment.propagateTo {
+			lookupSegment(target)
+		}
+		for _, route := range segment.staticRoutes {
+			if route.targetSegment != "" {
+				lookupSegment(route.targetSegment)
+			}
+		}
+	}
+
+	pairs := map[[2]string]bool{}
+	for _, connection := range TgwConnectivityMatrix {
+		a, b := connection.segments[0], connection.segments[1]
+		if b < a {
+			a, b = b, a
+		}
+		if pairs[[2]string{a, b}] {
+			panic(fmt.Sprintf("transit gateway segments %s and %s are connected more than once", a, b))
+		}
+		pairs[[2]string{a, b}] = true
+
+		switch connection.connectivity {
+		case TgwDirect:
+		case TgwInspected, TgwIsolated:
+			for _, name := range []string{a, b} {
+				if len(lookupSegment(name).cidrs) == 0 {
+					panic(fmt.Sprintf("transit gateway segment %q needs cidrs to be %s", name, connection.connectivity))
+				}
+			}
+		default:
+			panic(fmt.Sprintf("unknown connectivity %q between %s and %s", connection.connectivity, a, b))
+		}
+		lookupSegment(a)
+		lookupSegment(b)
+	}
+}
+
+// Creates the route tables of the segments that are not in routeTables and
+// returns the route table IDs of all segments.
+func createSegmentRouteTables(stack awscdk.Stack, transitGWId *string, naming ResourceNaming, routeTables map[string]*string) map[string]*string {
+	ids := map[string]*string{}
+	for name, id := range routeTables {
+		ids[name] = id
+	}
+
+	for _, segment := range resolvedTgwSegments() {
+		if _, ok := ids[segment.name]; ok {
+			continue
+		}
+		rt := ec2.NewCfnTransitGatewayRouteTable(stack, jsii.String(fmt.Sprintf("%s-route-table", segment.name)), &ec2.CfnTransitGatewayRouteTableProps{
+			TransitGatewayId: transitGWId,
+			Tags: &[]*awscdk.CfnTag{
+				{
+					Key:   jsii.String("Name"),
+					Value: naming.name(stack, segmentRouteTableName(segment.name)),
+				},
+			},
+		})
+		awscdk.NewCfnOutput(stack, jsii.String(fmt.Sprintf("%s-rt-output", segment.name)), &awscdk.CfnOutputProps{
+			Value:      rt.Ref(),
+			ExportName: jsii.String(SegmentRouteTableExportName(segment.name)),
+		})
+		ids[segment.name] = rt.Ref()
+	}
+
+	return ids
+}
+
+// Blackhole routes are static, so they are created with the stack. Routes
+// to the inspection VPC are created by the inspection stack, routes to
+// other attachments by the attachment handler.
+func createSegmentBlackholeRoutes(stack awscdk.Stack, routeTables map[string]*string) {
+	for _, segment := range resolvedTgwSegments() {
+		for _, route := range segment.staticRoutes {
+			if route.targetSegment != "" {
+				continue
+			}
+			ec2.NewCfnTransitGatewayRoute(stack, jsii.String(fmt.Sprintf("Blackhole-%s-%s", segment.name, strings.ReplaceAll(route.destinationCidr, "/", "-"))), &ec2.CfnTransitGatewayRouteProps{
+				Blackhole:                  jsii.Bool(true),
+				DestinationCidrBlock:       jsii.String(route.destinationCidr),
+				TransitGatewayRouteTableId: routeTables[segment.name],
+			})
+		}
+	}
+}
+
+// Creates the static routes of all segments towards the attachment of a
+// segment deployed by CDK. The route tables are imported from the transit
+// gateway stack.
+func createSegmentStaticRoutes(stack awscdk.Stack, targetSegment string, attachmentId *string) {
+	for _, segment := range resolvedTgwSegments() {
+		for _, route := range segment.staticRoutes {
+			if route.targetSegment != targetSegment {
+				continue
+			}
+			ec2.NewCfnTransitGatewayRoute(stack, jsii.String(fmt.Sprintf("TGW_Route-%s-%s", segment.name, strings.ReplaceAll(route.destinationCidr, "/", "-"))), &ec2.CfnTransitGatewayRouteProps{
+				DestinationCidrBlock:       jsii.String(route.destinationCidr),
+				TransitGatewayAttachmentId: attachmentId,
+				TransitGatewayRouteTableId: awscdk.Fn_ImportValue(jsii.String(SegmentRouteTableExportName(segment.name))),
+			})
+		}
+	}
+}
+
+// Publishes the segment mapping as JSON for the attachment handler.
+func publishSegmentMapping(stack awscdk.Stack, routeTables map[string]*string, quarantineRouteTableId *string) ssm.StringParameter {
+	mapping := segmentMapping{
+		QuarantineRouteTableId: *quarantineRouteTableId,
+		Segments:               map[string]segmentTables{},
+		StaticRoutes:           []segmentStaticRoute{},
+	}
+	for _, segment := range resolvedTgwSegments() {
+		tables := segmentTables{
+			AssociationRouteTableId:  *routeTables[segment.name],
+			PropagationRouteTableIds: []string{},
+		}
+		for _, target := range segment.propagateTo {
+			tables.PropagationRouteTableIds = append(tables.PropagationRouteTableIds, *routeTables[target])
+		}
+		mapping.Segments[segment.name] = tables
+
+		for _, route := range segment.staticRoutes {
+			if route.targetSegment == "" || route.targetSegment == "inspection" {
+				continue
+			}
+			mapping.StaticRoutes = append(mapping.StaticRoutes, segmentStaticRoute{
+				RouteTableId:    *routeTables[segment.name],
+				DestinationCidr: route.destinationCidr,
+				TargetSegment:   route.targetSegment,
+			})
+		}
+	}
+
+	// The route table IDs are tokens, which CloudFormation resolves inside
+	// the JSON string.
+	value, err := json.Marshal(mapping)
+	if err != nil {
+		panic(err)
+	}
+
+	return ssm.NewStringParameter(stack, jsii.String("TgwSegmentsParameter"), &ssm.StringParameterProps{
+		ParameterName: jsii.String(TgwSegmentsParameterName),
+		Description:   jsii.String("Transit gateway segment mapping used by the attachment handler"),
+		StringValue:   jsii.String(string(value)),
+	})
+}
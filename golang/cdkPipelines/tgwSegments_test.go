@@ -0,0 +1,291 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cdkPipelines
+
+import (
+	"reflect"
+	"testing"
+)
+
+var exampleSegments = []TgwSegment{
+	{name: "workload", cidrs: []string{"10.110.0.0/15"}, propagateTo: []string{"inspection"}},
+	{name: "inspection"},
+	{name: "prod", cidrs: []string{"10.16.0.0/12"}},
+	{name: "non-prod", cidrs: []string{"10.32.0.0/12"}},
+	{name: "shared-services", cidrs: []string{"10.48.0.0/16"}},
+	{name: "on-prem", cidrs: []string{"10.250.0.0/16"}},
+}
+
+// Replaces the segment config for the duration of the test.
+func withSegments(t *testing.T, segments []TgwSegment, matrix []TgwSegmentConnection, isolation bool) {
+	t.Helper()
+	oldSegments, oldMatrix, oldIsolation := TgwSegments, TgwConnectivityMatrix, SpokeIsolation
+	oldDirectConnect, oldSdWan, oldSharedServices := DirectConnect, SdWan, SharedServices
+	t.Cleanup(func() {
+		TgwSegments, TgwConnectivityMatrix, SpokeIsolation = oldSegments, oldMatrix, oldIsolation
+		DirectConnect, SdWan, SharedServices = oldDirectConnect, oldSdWan, oldSharedServices
+	})
+	TgwSegments, TgwConnectivityMatrix, SpokeIsolation = segments, matrix, isolation
+	DirectConnect, SdWan, SharedServices = nil, nil, nil
+}
+
+func TestDefaultTgwSegments(t *testing.T) {
+	withSegments(t, TgwSegments, TgwConnectivityMatrix, SpokeIsolation)
+	validateTgwSegments()
+
+	var names []string
+	for _, segment := range resolvedTgwSegments() {
+		names = append(names, segment.name)
+	}
+	if !reflect.DeepEqual(names, []string{"workload", "inspection"}) {
+		t.Errorf("default segments = %v, want workload and inspection", names)
+	}
+}
+
+func TestValidateTgwSegments(t *testing.T) {
+	tests := []struct {
+		name           string
+		segments       []TgwSegment
+		matrix         []TgwSegmentConnection
+		sharedServices bool
+		wantPanic      bool
+	}{
+		{
+			name:     "example segments and matrix",
+			segments: exampleSegments,
+			matrix: []TgwSegmentConnection{
+				{segments: [2]string{"prod", "shared-services"}, connectivity: TgwDirect},
+				{segments: [2]string{"prod", "on-prem"}, connectivity: TgwInspected},
+				{segments: [2]string{"prod", "non-prod"}, connectivity: TgwIsolated},
+			},
+		},
+		{
+			name:      "missing workload segment",
+			segments:  []TgwSegment{{name: "inspection"}},
+			wantPanic: true,
+		},
+		{
+			name:      "duplicate segment",
+			segments:  append(append([]TgwSegment{}, exampleSegments...), TgwSegment{name: "prod"}),
+			wantPanic: true,
+		},
+		{
+			name:      "propagation to an unknown segment",
+			segments:  []TgwSegment{{name: "workload", propagateTo: []string{"prod"}}, {name: "inspection"}},
+			wantPanic: true,
+		},
+		{
+			name: "static route to an unknown segment",
+			segments: []TgwSegment{
+				{name: "workload", staticRoutes: []TgwStaticRoute{{destinationCidr: "10.0.0.0/8", targetSegment: "prod"}}},
+				{name: "inspection"},
+			},
+			wantPanic: true,
+		},
+		{
+			name:      "connection to an unknown segment",
+			segments:  exampleSegments[:2],
+			matrix:    []TgwSegmentConnection{{segments: [2]string{"workload", "prod"}, connectivity: TgwDirect}},
+			wantPanic: true,
+		},
+		{
+			name:     "segments connected twice",
+			segments: exampleSegments,
+			matrix: []TgwSegmentConnection{
+				{segments: [2]string{"prod", "on-prem"}, connectivity: TgwInspected},
+				{segments: [2]string{"on-prem", "prod"}, connectivity: TgwIsolated},
+			},
+			wantPanic: true,
+		},
+		{
+			name:      "inspected segment without cidrs",
+			segments:  exampleSegments,
+			matrix:    []TgwSegmentConnection{{segments: [2]string{"prod", "inspection"}, connectivity: TgwInspected}},
+			wantPanic: true,
+		},
+		{
+			name:      "unknown connectivity",
+			segments:  exampleSegments,
+			matrix:    []TgwSegmentConnection{{segments: [2]string{"prod", "on-prem"}, connectivity: "peered"}},
+			wantPanic: true,
+		},
+		{
+			name:           "shared services without their segment",
+			segments:       exampleSegments[:2],
+			sharedServices: true,
+			wantPanic:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withSegments(t, tt.segments, tt.matrix, false)
+			if tt.sharedServices {
+				SharedServices = &SharedServicesConfig{cidr: "10.48.0.0/16"}
+			}
+			defer func() {
+				if r := recover(); (r != nil) != tt.wantPanic {
+					t.Errorf("validateTgwSegments panic = %v, want panic %v", r, tt.wantPanic)
+				}
+			}()
+			validateTgwSegments()
+		})
+	}
+}
+
+func TestResolvedTgwSegments(t *testing.T) {
+	withSegments(t, exampleSegments, []TgwSegmentConnection{
+		{segments: [2]string{"prod", "shared-services"}, connectivity: TgwDirect},
+		{segments: [2]string{"prod", "on-prem"}, connectivity: TgwInspected},
+		{segments: [2]string{"prod", "non-prod"}, connectivity: TgwIsolated},
+		{segments: [2]string{"non-prod", "non-prod"}, connectivity: TgwInspected},
+	}, false)
+
+	want := map[string]TgwSegment{
+		"workload":   {propagateTo: []string{"inspection"}, staticRoutes: []TgwStaticRoute{}},
+		"inspection": {propagateTo: []string{}, staticRoutes: []TgwStaticRoute{}},
+		"prod": {
+			propagateTo: []string{"shared-services", "inspection"},
+			staticRoutes: []TgwStaticRoute{
+				{destinationCidr: "10.250.0.0/16", targetSegment: "inspection"},
+				{destinationCidr: "10.32.0.0/12"},
+			},
+		},
+		"non-prod": {
+			propagateTo: []string{"inspection"},
+			staticRoutes: []TgwStaticRoute{
+				{destinationCidr: "10.16.0.0/12"},
+				{destinationCidr: "10.32.0.0/12", targetSegment: "inspection"},
+			},
+		},
+		"shared-services": {propagateTo: []string{"prod"}, staticRoutes: []TgwStaticRoute{}},
+		"on-prem": {
+			propagateTo: []string{"inspection"},
+			staticRoutes: []TgwStaticRoute{
+				{destinationCidr: "10.16.0.0/12", targetSegment: "inspection"},
+			},
+		},
+	}
+
+	for _, segment := range resolvedTgwSegments() {
+		w := want[segment.name]
+		if !reflect.DeepEqual(segment.propagateTo, w.propagateTo) {
+			t.Errorf("%s propagateTo = %v, want %v", segment.name, segment.propagateTo, w.propagateTo)
+		}
+		if !reflect.DeepEqual(segment.staticRoutes, w.staticRoutes) {
+			t.Errorf("%s staticRoutes = %v, want %v", segment.name, segment.staticRoutes, w.staticRoutes)
+		}
+	}
+}
+
+func TestResolvedTgwSegmentsKeepsConfig(t *testing.T) {
+	withSegments(t, append([]TgwSegment{}, exampleSegments...), []TgwSegmentConnection{
+		{segments: [2]string{"workload", "on-prem"}, connectivity: TgwInspected},
+	}, false)
+
+	resolvedTgwSegments()
+	if !reflect.DeepEqual(TgwSegments, exampleSegments) {
+		t.Errorf("resolvedTgwSegments changed TgwSegments to %v", TgwSegments)
+	}
+}
+
+func TestResolvedTgwSegmentsSpokeIsolation(t *testing.T) {
+	withSegments(t, exampleSegments, []TgwSegmentConnection{
+		{segments: [2]string{"workload", "on-prem"}, connectivity: TgwInspected},
+	}, true)
+
+	var workload TgwSegment
+	for _, segment := range resolvedTgwSegments() {
+		if segment.name == "workload" {
+			workload = segment
+		}
+	}
+	want := []TgwStaticRoute{
+		{destinationCidr: "10.250.0.0/16", targetSegment: "inspection"},
+		{destinationCidr: OrganizationCidr, targetSegment: "inspection"},
+		{destinationCidr: "10.16.0.0/12"},
+		{destinationCidr: "10.32.0.0/12"},
+		{destinationCidr: "10.48.0.0/16"},
+	}
+	if !reflect.DeepEqual(workload.staticRoutes, want) {
+		t.Errorf("workload staticRoutes = %v, want %v", workload.staticRoutes, want)
+	}
+}
+
+func TestSpokeIsolationViolations(t *testing.T) {
+	tests := []struct {
+		name     string
+		segments []TgwSegment
+		matrix   []TgwSegmentConnection
+		want     []string
+	}{
+		{
+			name:     "inspected and isolated segments",
+			segments: exampleSegments,
+			matrix: []TgwSegmentConnection{
+				{segments: [2]string{"workload", "on-prem"}, connectivity: TgwInspected},
+				{segments: [2]string{"prod", "shared-services"}, connectivity: TgwInspected},
+				{segments: [2]string{"prod", "non-prod"}, connectivity: TgwIsolated},
+			},
+		},
+		{
+			name:     "workload connected directly",
+			segments: exampleSegments,
+			matrix: []TgwSegmentConnection{
+				{segments: [2]string{"workload", "shared-services"}, connectivity: TgwDirect},
+			},
+			want: []string{
+				"segment workload propagates into the shared-services route table, bypassing the firewall",
+				"segment shared-services propagates into the workload route table, bypassing the firewall",
+			},
+		},
+		{
+			name:     "other spoke segments connected directly",
+			segments: exampleSegments,
+			matrix: []TgwSegmentConnection{
+				{segments: [2]string{"prod", "shared-services"}, connectivity: TgwDirect},
+			},
+			want: []string{
+				"segment prod propagates into the shared-services route table, bypassing the firewall",
+				"segment shared-services propagates into the prod route table, bypassing the firewall",
+			},
+		},
+		{
+			name: "static route past the firewall",
+			segments: []TgwSegment{
+				exampleSegments[0],
+				exampleSegments[1],
+				{name: "prod", cidrs: []string{"10.16.0.0/12"}, staticRoutes: []TgwStaticRoute{
+					{destinationCidr: "10.48.0.0/16", targetSegment: "shared-services"},
+				}},
+				exampleSegments[4],
+			},
+			want: []string{
+				"prod route to 10.48.0.0/16 points at segment shared-services, bypassing the firewall",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withSegments(t, tt.segments, tt.matrix, true)
+			validateTgwSegments()
+			if got := spokeIsolationViolations(resolvedTgwSegments()); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("violations = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
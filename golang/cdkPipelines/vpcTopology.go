@@ -0,0 +1,169 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cdkPipelines
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	ec2 "github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
+	"github.com/aws/jsii-runtime-go"
+)
+
+type VpcAzConfig struct {
+	// Number of AZs to use when availabilityZones is empty. Zero keeps the
+	// CDK default.
+	maxAzs float64
+	// Explicit AZ IDs (euc1-az1) or AZ names (eu-central-1a). AZ IDs are
+	// resolved to the AZ names of the VPC's account through AzIdToName.
+	// Prefer AZ IDs, AZ names map to different physical AZs in different
+	// accounts.
+	availabilityZones []string
+}
+
+var azIdPattern = regexp.MustCompile(`^[a-z]{2,5}[0-9]+-az[0-9]+$`)
+
+// Applies the AZ selection to the VPC props of a VPC in the given account.
+func applyAzConfig(vpcProps *ec2.VpcProps, config VpcAzConfig, account string) {
+	if len(config.availabilityZones) > 0 {
+		var names []*string
+		for _, az := range config.availabilityZones {
+			names = append(names, jsii.String(resolveAzName(account, az)))
+		}
+		vpcProps.AvailabilityZones = &names
+		vpcProps.MaxAzs = nil
+		return
+	}
+	if config.maxAzs > 0 {
+		vpcProps.MaxAzs = jsii.Number(config.maxAzs)
+	}
+}
+
+func resolveAzName(account string, az string) string {
+	if !azIdPattern.MatchString(az) {
+		return az
+	}
+	name, ok := AzIdToName[account][az]
+	if !ok {
+		panic(fmt.Sprintf("no AZ name configured for AZ ID %q in account %q, add it to AzIdToName", az, account))
+	}
+	return name
+}
+
+// Returns the AZ IDs of the given AZ names in the account, or false if a name
+// is not known yet or has no AZ ID configured.
+func resolveAzIds(account string, azs *[]*string) ([]string, bool) {
+	var ids []string
+	for _, az := range *azs {
+		if *awscdk.Token_IsUnresolved(az) {
+			return nil, false
+		}
+		found := false
+		for id, name := range AzIdToName[account] {
+			if name == *az {
+				ids = append(ids, id)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return ids, true
+}
+
+// AZ ID of the subnet when AzIdToName knows it, so the route handler
+// selects the firewall endpoint by physical AZ. Empty otherwise.
+func subnetAzId(stack awscdk.Stack, subnet ec2.ISubnet) string {
+	azs := []*string{subnet.AvailabilityZone()}
+	ids, ok := resolveAzIds(stackAccount(stack), &azs)
+	if !ok {
+		return ""
+	}
+	return ids[0]
+}
+
+// Account of the stack, or an empty string for environment agnostic stacks.
+func stackAccount(stack awscdk.Stack) string {
+	if *awscdk.Token_IsUnresolved(stack.Account()) {
+		return ""
+	}
+	return *stack.Account()
+}
+
+func subnetMask(mask float64, defaultMask float64) *float64 {
+	if mask > 0 {
+		return jsii.Number(mask)
+	}
+	return jsii.Number(defaultMask)
+}
+
+// Every spoke attachment AZ needs a firewall endpoint in the same physical AZ,
+// otherwise the traffic of that spoke crosses AZs (or has no path at all) on
+// its way to the inspection VPC. AZs are compared by AZ ID, as the same AZ
+// name maps to different physical AZs in different accounts. Within a single
+// account the names are compared when no AZ IDs are configured. AZs that are
+// only known at deploy time can't be checked here.
+func validateSpokeAzs(hub NetworkFirewallStackOutputs, spoke InspectionWorkloadStackOutputs) {
+	hubAzs, hubOk := resolveAzIds(hub.account, hub.availabilityZones)
+	spokeAzs, spokeOk := resolveAzIds(spoke.account, spoke.availabilityZones)
+
+	if !hubOk || !spokeOk {
+		if hub.account != spoke.account {
+			awscdk.Annotations_Of(spoke.Stack).AddWarning(jsii.String(fmt.Sprintf(
+				"can't check that the spoke AZs have a firewall endpoint, add the AZ IDs of accounts %q and %q to AzIdToName",
+				hub.account, spoke.account)))
+			return
+		}
+		hubAzs, hubOk = resolvedNames(hub.availabilityZones)
+		spokeAzs, spokeOk = resolvedNames(spoke.availabilityZones)
+		if !hubOk || !spokeOk {
+			return
+		}
+	}
+
+	endpoints := map[string]bool{}
+	for _, az := range hubAzs {
+		endpoints[az] = true
+	}
+
+	var missing []string
+	for _, az := range spokeAzs {
+		if !endpoints[az] {
+			missing = append(missing, az)
+		}
+	}
+
+	if len(missing) > 0 {
+		awscdk.Annotations_Of(spoke.Stack).AddError(jsii.String(fmt.Sprintf(
+			"spoke AZs %s have no firewall endpoint in the inspection VPC",
+			strings.Join(missing, ", "))))
+	}
+}
+
+func resolvedNames(azs *[]*string) ([]string, bool) {
+	var names []string
+	for _, az := range *azs {
+		if *awscdk.Token_IsUnresolved(az) {
+			return nil, false
+		}
+		names = append(names, *az)
+	}
+	return names, true
+}
@@ -0,0 +1,107 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cdkPipelines
+
+import (
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	ec2 "github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
+	ecs "github.com/aws/aws-cdk-go/awscdk/v2/awsecs"
+	iam "github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// Resources deployed into the private subnets of a spoke VPC.
+type WorkloadTemplate interface {
+	Deploy(stack awscdk.Stack, vpc ec2.Vpc)
+}
+
+// Networking only.
+type NoWorkload struct{}
+
+func (NoWorkload) Deploy(stack awscdk.Stack, vpc ec2.Vpc) {}
+
+// A t4g.micro Amazon Linux 2 instance reachable through Session Manager,
+// answering ICMP from anywhere to probe the inspection path.
+type TestInstanceWorkload struct{}
+
+func (TestInstanceWorkload) Deploy(stack awscdk.Stack, vpc ec2.Vpc) {
+	SSMRole := iam.NewRole(stack, jsii.String("SSMRole"), &iam.RoleProps{
+		AssumedBy:       iam.NewServicePrincipal(jsii.String("ec2.amazonaws.com"), nil),
+		ManagedPolicies: &[]iam.IManagedPolicy{iam.ManagedPolicy_FromAwsManagedPolicyName(jsii.String("AmazonSSMManagedInstanceCore"))},
+	})
+
+	securityGroup := ec2.NewSecurityGroup(stack, jsii.String("WorkloadEC2SG"), &ec2.SecurityGroupProps{
+		SecurityGroupName: jsii.String("workload-sg"),
+		Vpc:               vpc,
+	})
+
+	securityGroup.AddIngressRule(ec2.Peer_AnyIpv4(), ec2.Port_AllIcmp(), jsii.String("WorkloadIngressRule"), jsii.Bool(true))
+
+	ec2.NewInstance(stack, jsii.String("WorkloadEC2"), &ec2.InstanceProps{
+		Vpc:          vpc,
+		VpcSubnets:   &ec2.SubnetSelection{SubnetGroupName: jsii.String("Private")},
+		InstanceType: ec2.InstanceType_Of(ec2.InstanceClass_BURSTABLE4_GRAVITON, ec2.InstanceSize_MICRO),
+		MachineImage: ec2.MachineImage_LatestAmazonLinux(&ec2.AmazonLinuxImageProps{
+			CpuType:    ec2.AmazonLinuxCpuType_ARM_64,
+			Generation: ec2.AmazonLinuxGeneration_AMAZON_LINUX_2,
+		}),
+		Role:          SSMRole,
+		SecurityGroup: securityGroup,
+	})
+}
+
+// A Fargate service in the private subnets. The image is pulled and the logs
+// are shipped through the inspection VPC, so the firewall must allow HTTPS.
+type EcsServiceWorkload struct {
+	image         string
+	containerPort float64
+	desiredCount  float64
+}
+
+func (w EcsServiceWorkload) Deploy(stack awscdk.Stack, vpc ec2.Vpc) {
+	cluster := ecs.NewCluster(stack, jsii.String("WorkloadCluster"), &ecs.ClusterProps{
+		Vpc: vpc,
+	})
+
+	taskDefinition := ecs.NewFargateTaskDefinition(stack, jsii.String("WorkloadTask"), &ecs.FargateTaskDefinitionProps{
+		Cpu:            jsii.Number(256),
+		MemoryLimitMiB: jsii.Number(512),
+		RuntimePlatform: &ecs.RuntimePlatform{
+			CpuArchitecture:       ecs.CpuArchitecture_ARM64(),
+			OperatingSystemFamily: ecs.OperatingSystemFamily_LINUX(),
+		},
+	})
+	taskDefinition.AddContainer(jsii.String("Workload"), &ecs.ContainerDefinitionOptions{
+		Image:        ecs.ContainerImage_FromRegistry(jsii.String(w.image), nil),
+		Logging:      ecs.LogDriver_AwsLogs(&ecs.AwsLogDriverProps{StreamPrefix: jsii.String("workload")}),
+		PortMappings: &[]*ecs.PortMapping{{ContainerPort: jsii.Number(w.containerPort)}},
+	})
+
+	service := ecs.NewFargateService(stack, jsii.String("WorkloadService"), &ecs.FargateServiceProps{
+		Cluster:        cluster,
+		TaskDefinition: taskDefinition,
+		DesiredCount:   jsii.Number(w.desiredCount),
+		VpcSubnets:     &ec2.SubnetSelection{SubnetGroupName: jsii.String("Private")},
+	})
+	service.Connections().AllowFrom(ec2.Peer_Ipv4(jsii.String(OrganizationCidr)), ec2.Port_Tcp(jsii.Number(w.containerPort)), jsii.String("Workload port from the organization"))
+}
+
+// Any other resources, e.g. a construct of the application team.
+type WorkloadFunc func(stack awscdk.Stack, vpc ec2.Vpc)
+
+func (f WorkloadFunc) Deploy(stack awscdk.Stack, vpc ec2.Vpc) {
+	f(stack, vpc)
+}
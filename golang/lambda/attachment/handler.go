@@ -0,0 +1,491 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/smithy-go"
+)
+
+type AttachmentAPI interface {
+	DescribeTransitGatewayAttachments(ctx context.Context, params *ec2.DescribeTransitGatewayAttachmentsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeTransitGatewayAttachmentsOutput, error)
+	AssociateTransitGatewayRouteTable(ctx context.Context, params *ec2.AssociateTransitGatewayRouteTableInput, optFns ...func(*ec2.Options)) (*ec2.AssociateTransitGatewayRouteTableOutput, error)
+	DisassociateTransitGatewayRouteTable(ctx context.Context, params *ec2.DisassociateTransitGatewayRouteTableInput, optFns ...func(*ec2.Options)) (*ec2.DisassociateTransitGatewayRouteTableOutput, error)
+	GetTransitGatewayAttachmentPropagations(ctx context.Context, params *ec2.GetTransitGatewayAttachmentPropagationsInput, optFns ...func(*ec2.Options)) (*ec2.GetTransitGatewayAttachmentPropagationsOutput, error)
+	EnableTransitGatewayRouteTablePropagation(ctx context.Context, params *ec2.EnableTransitGatewayRouteTablePropagationInput, optFns ...func(*ec2.Options)) (*ec2.EnableTransitGatewayRouteTablePropagationOutput, error)
+	DisableTransitGatewayRouteTablePropagation(ctx context.Context, params *ec2.DisableTransitGatewayRouteTablePropagationInput, optFns ...func(*ec2.Options)) (*ec2.DisableTransitGatewayRouteTablePropagationOutput, error)
+}
+
+type RouteAPI interface {
+	CreateTransitGatewayRoute(ctx context.Context, params *ec2.CreateTransitGatewayRouteInput, optFns ...func(*ec2.Options)) (*ec2.CreateTransitGatewayRouteOutput, error)
+	ReplaceTransitGatewayRoute(ctx context.Context, params *ec2.ReplaceTransitGatewayRouteInput, optFns ...func(*ec2.Options)) (*ec2.ReplaceTransitGatewayRouteOutput, error)
+	DeleteTransitGatewayRoute(ctx context.Context, params *ec2.DeleteTransitGatewayRouteInput, optFns ...func(*ec2.Options)) (*ec2.DeleteTransitGatewayRouteOutput, error)
+	SearchTransitGatewayRoutes(ctx context.Context, params *ec2.SearchTransitGatewayRoutesInput, optFns ...func(*ec2.Options)) (*ec2.SearchTransitGatewayRoutesOutput, error)
+}
+
+type ParameterAPI interface {
+	GetParameter(ctx context.Context, params *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error)
+}
+
+type EC2API interface {
+	AttachmentAPI
+	RouteAPI
+}
+
+type handler struct {
+	ec2               EC2API
+	ssm               ParameterAPI
+	segmentsParameter string
+	sleep             func(time.Duration)
+	logger            *slog.Logger
+}
+
+// Segment mapping published by the transit gateway stack.
+type segmentMapping struct {
+	QuarantineRouteTableId string                   `json:"quarantineRouteTableId"`
+	Segments               map[string]segmentTables `json:"segments"`
+	StaticRoutes           []segmentStaticRoute     `json:"staticRoutes"`
+}
+
+type segmentTables struct {
+	AssociationRouteTableId  string   `json:"associationRouteTableId"`
+	PropagationRouteTableIds []string `json:"propagationRouteTableIds"`
+}
+
+type segmentStaticRoute struct {
+	RouteTableId    string `json:"routeTableId"`
+	DestinationCidr string `json:"destinationCidr"`
+	TargetSegment   string `json:"targetSegment"`
+}
+
+type cloudTrailDetail struct {
+	EventName         string          `json:"eventName"`
+	RequestParameters json.RawMessage `json:"requestParameters"`
+	ResponseElements  json.RawMessage `json:"responseElements"`
+}
+
+type createAttachmentResponse struct {
+	Response struct {
+		Attachment struct {
+			Id string `json:"transitGatewayAttachmentId"`
+		} `json:"transitGatewayVpcAttachment"`
+	} `json:"CreateTransitGatewayVpcAttachmentResponse"`
+}
+
+type deleteAttachmentRequest struct {
+	Request struct {
+		Id string `json:"TransitGatewayAttachmentId"`
+	} `json:"DeleteTransitGatewayVpcAttachmentRequest"`
+}
+
+type tagsRequest struct {
+	ResourcesSet struct {
+		Items []struct {
+			ResourceId string `json:"resourceId"`
+		} `json:"items"`
+	} `json:"resourcesSet"`
+}
+
+// Handles attachment creation, deletion and tag changes. The routeTable tag
+// of an attachment names its segment in the mapping published by the
+// transit gateway stack. Attachments without the tag or with an unknown
+// segment are associated with the quarantine route table, which has no
+// routes.
+func (h *handler) handle(ctx context.Context, event events.CloudWatchEvent) error {
+	var detail cloudTrailDetail
+	if err := json.Unmarshal(event.Detail, &detail); err != nil {
+		return fmt.Errorf("parsing event detail: %w", err)
+	}
+	h.logger.Info("received event", "eventName", detail.EventName, "eventId", event.ID)
+
+	switch detail.EventName {
+	case "CreateTransitGatewayVpcAttachment":
+		var response createAttachmentResponse
+		if err := json.Unmarshal(detail.ResponseElements, &response); err != nil {
+			return fmt.Errorf("parsing response elements: %w", err)
+		}
+		return h.apply(ctx, response.Response.Attachment.Id)
+
+	case "DeleteTransitGatewayVpcAttachment":
+		var request deleteAttachmentRequest
+		if err := json.Unmarshal(detail.RequestParameters, &request); err != nil {
+			return fmt.Errorf("parsing request parameters: %w", err)
+		}
+		return h.cleanup(ctx, request.Request.Id)
+
+	case "CreateTags", "DeleteTags":
+		var request tagsRequest
+		if err := json.Unmarshal(detail.RequestParameters, &request); err != nil {
+			return fmt.Errorf("parsing request parameters: %w", err)
+		}
+		var errs []error
+		for _, item := range request.ResourcesSet.Items {
+			if !strings.HasPrefix(item.ResourceId, "tgw-attach-") {
+				continue
+			}
+			if err := h.apply(ctx, item.ResourceId); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	}
+
+	return fmt.Errorf("incorrect event type %q", detail.EventName)
+}
+
+// Associates the attachment with the route table of the segment named by
+// its routeTable tag, and sets up its propagations and the static routes
+// pointing at it.
+func (h *handler) apply(ctx context.Context, attachmentId string) error {
+	attachment, err := h.describe(ctx, attachmentId)
+	if err != nil {
+		return err
+	}
+	if attachment == nil {
+		h.logger.Warn("attachment not found, skipping", "attachment", attachmentId)
+		return nil
+	}
+	if skip, reason := skipped(attachment); skip {
+		h.logger.Info(reason+", skipping", "attachment", attachmentId, "resourceType", attachment.ResourceType)
+		return nil
+	}
+
+	mapping, err := h.segmentMapping(ctx)
+	if err != nil {
+		return err
+	}
+
+	segmentName := attachmentSegment(attachment)
+	segment, ok := mapping.Segments[segmentName]
+	if !ok {
+		h.logger.Warn("missing or unknown routeTable tag, quarantining attachment",
+			"attachment", attachmentId, "routeTableTag", segmentName)
+		segmentName = ""
+		segment = segmentTables{AssociationRouteTableId: mapping.QuarantineRouteTableId}
+	}
+	if segment.AssociationRouteTableId == "" {
+		return fmt.Errorf("no route table for routeTable tag %q of attachment %s", segmentName, attachmentId)
+	}
+	h.logger.Info("resolved segment", "attachment", attachmentId, "segment", segmentName,
+		"routeTable", segment.AssociationRouteTableId, "propagations", segment.PropagationRouteTableIds)
+
+	if err := h.associate(ctx, attachment, segment.AssociationRouteTableId); err != nil {
+		return err
+	}
+	if err := h.setPropagations(ctx, attachmentId, segment.PropagationRouteTableIds); err != nil {
+		return err
+	}
+	return h.syncStaticRoutes(ctx, mapping, attachmentId, segmentName)
+}
+
+func attachmentSegment(attachment *types.TransitGatewayAttachment) string {
+	for _, tag := range attachment.Tags {
+		if aws.ToString(tag.Key) == "routeTable" {
+			return aws.ToString(tag.Value)
+		}
+	}
+	return ""
+}
+
+// Only VPC attachments are associated through their tags. Other attachment
+// types, and VPC attachments tagged associationManagedBy=cloudformation, are
+// associated by the stack that creates them.
+func skipped(attachment *types.TransitGatewayAttachment) (bool, string) {
+	if attachment.ResourceType != types.TransitGatewayAttachmentResourceTypeVpc {
+		return true, "not a VPC attachment"
+	}
+	for _, tag := range attachment.Tags {
+		if aws.ToString(tag.Key) == "associationManagedBy" && aws.ToString(tag.Value) == "cloudformation" {
+			return true, "association managed by CloudFormation"
+		}
+	}
+	return false, ""
+}
+
+// Removes the association and propagations of a deleted attachment. The
+// transit gateway drops them with the attachment as well, so attachments
+// that are already gone are not an error.
+func (h *handler) cleanup(ctx context.Context, attachmentId string) error {
+	attachment, err := h.describe(ctx, attachmentId)
+	if err != nil {
+		return err
+	}
+	if attachment == nil {
+		h.logger.Info("attachment already deleted", "attachment", attachmentId)
+		return nil
+	}
+	if skip, reason := skipped(attachment); skip {
+		h.logger.Info(reason+", skipping", "attachment", attachmentId, "resourceType", attachment.ResourceType)
+		return nil
+	}
+
+	if attachment.Association != nil {
+		routeTableId := aws.ToString(attachment.Association.TransitGatewayRouteTableId)
+		_, err := h.ec2.DisassociateTransitGatewayRouteTable(ctx, &ec2.DisassociateTransitGatewayRouteTableInput{
+			TransitGatewayAttachmentId: aws.String(attachmentId),
+			TransitGatewayRouteTableId: aws.String(routeTableId),
+		})
+		if err != nil && !isGone(err) {
+			return fmt.Errorf("disassociating attachment %s: %w", attachmentId, err)
+		}
+		h.logger.Info("disassociated deleted attachment", "attachment", attachmentId, "routeTable", routeTableId)
+	}
+
+	if err := h.setPropagations(ctx, attachmentId, nil); err != nil && !isGone(err) {
+		return err
+	}
+
+	mapping, err := h.segmentMapping(ctx)
+	if err != nil {
+		return err
+	}
+	return h.syncStaticRoutes(ctx, mapping, attachmentId, "")
+}
+
+func (h *handler) describe(ctx context.Context, attachmentId string) (*types.TransitGatewayAttachment, error) {
+	out, err := h.ec2.DescribeTransitGatewayAttachments(ctx, &ec2.DescribeTransitGatewayAttachmentsInput{
+		TransitGatewayAttachmentIds: []string{attachmentId},
+	})
+	if err != nil {
+		if isGone(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("describing attachment %s: %w", attachmentId, err)
+	}
+	if len(out.TransitGatewayAttachments) == 0 {
+		return nil, nil
+	}
+	return &out.TransitGatewayAttachments[0], nil
+}
+
+func (h *handler) segmentMapping(ctx context.Context) (*segmentMapping, error) {
+	out, err := h.ssm.GetParameter(ctx, &ssm.GetParameterInput{Name: aws.String(h.segmentsParameter)})
+	if err != nil {
+		return nil, fmt.Errorf("reading segment mapping %s: %w", h.segmentsParameter, err)
+	}
+	var mapping segmentMapping
+	if err := json.Unmarshal([]byte(aws.ToString(out.Parameter.Value)), &mapping); err != nil {
+		return nil, fmt.Errorf("parsing segment mapping %s: %w", h.segmentsParameter, err)
+	}
+	return &mapping, nil
+}
+
+// Moves the attachment to the route table. An attachment can only be
+// associated with one route table, so an existing association is removed
+// first and the handler waits until it is gone.
+func (h *handler) associate(ctx context.Context, attachment *types.TransitGatewayAttachment, routeTableId string) error {
+	attachmentId := aws.ToString(attachment.TransitGatewayAttachmentId)
+
+	if attachment.Association != nil {
+		current := aws.ToString(attachment.Association.TransitGatewayRouteTableId)
+		if current == routeTableId {
+			h.logger.Info("attachment already associated", "attachment", attachmentId, "routeTable", routeTableId)
+			return nil
+		}
+
+		h.logger.Info("removing association", "attachment", attachmentId, "routeTable", current)
+		_, err := h.ec2.DisassociateTransitGatewayRouteTable(ctx, &ec2.DisassociateTransitGatewayRouteTableInput{
+			TransitGatewayAttachmentId: aws.String(attachmentId),
+			TransitGatewayRouteTableId: aws.String(current),
+		})
+		if err != nil {
+			return fmt.Errorf("disassociating attachment %s: %w", attachmentId, err)
+		}
+
+		for {
+			h.sleep(2 * time.Second)
+			described, err := h.describe(ctx, attachmentId)
+			if err != nil {
+				return err
+			}
+			if described == nil || described.Association == nil {
+				break
+			}
+			h.logger.Info("waiting for disassociation", "attachment", attachmentId)
+		}
+	}
+
+	h.logger.Info("associating attachment", "attachment", attachmentId, "routeTable", routeTableId)
+	_, err := h.ec2.AssociateTransitGatewayRouteTable(ctx, &ec2.AssociateTransitGatewayRouteTableInput{
+		TransitGatewayAttachmentId: aws.String(attachmentId),
+		TransitGatewayRouteTableId: aws.String(routeTableId),
+	})
+	if err != nil {
+		return fmt.Errorf("associating attachment %s: %w", attachmentId, err)
+	}
+	return nil
+}
+
+// Propagates the attachment to exactly the given route tables, disabling
+// any other propagation.
+func (h *handler) setPropagations(ctx context.Context, attachmentId string, routeTableIds []string) error {
+	out, err := h.ec2.GetTransitGatewayAttachmentPropagations(ctx, &ec2.GetTransitGatewayAttachmentPropagationsInput{
+		TransitGatewayAttachmentId: aws.String(attachmentId),
+	})
+	if err != nil {
+		return fmt.Errorf("listing propagations of %s: %w", attachmentId, err)
+	}
+
+	wanted := map[string]bool{}
+	for _, id := range routeTableIds {
+		wanted[id] = true
+	}
+	existing := map[string]bool{}
+	for _, propagation := range out.TransitGatewayAttachmentPropagations {
+		id := aws.ToString(propagation.TransitGatewayRouteTableId)
+		if propagation.State == types.TransitGatewayPropagationStateDisabled || propagation.State == types.TransitGatewayPropagationStateDisabling {
+			continue
+		}
+		existing[id] = true
+		if wanted[id] {
+			continue
+		}
+		h.logger.Info("disabling propagation", "attachment", attachmentId, "routeTable", id)
+		_, err := h.ec2.DisableTransitGatewayRouteTablePropagation(ctx, &ec2.DisableTransitGatewayRouteTablePropagationInput{
+			TransitGatewayAttachmentId: aws.String(attachmentId),
+			TransitGatewayRouteTableId: aws.String(id),
+		})
+		if err != nil {
+			return fmt.Errorf("disabling propagation of %s to %s: %w", attachmentId, id, err)
+		}
+	}
+
+	for _, id := range routeTableIds {
+		if existing[id] {
+			continue
+		}
+		h.logger.Info("enabling propagation", "attachment", attachmentId, "routeTable", id)
+		_, err := h.ec2.EnableTransitGatewayRouteTablePropagation(ctx, &ec2.EnableTransitGatewayRouteTablePropagationInput{
+			TransitGatewayAttachmentId: aws.String(attachmentId),
+			TransitGatewayRouteTableId: aws.String(id),
+		})
+		if err != nil {
+			return fmt.Errorf("enabling propagation of %s to %s: %w", attachmentId, id, err)
+		}
+	}
+	return nil
+}
+
+// Points the static routes targeting the segment at the attachment, and
+// removes static routes of other segments that still point at it. An empty
+// segment removes all static routes pointing at the attachment.
+func (h *handler) syncStaticRoutes(ctx context.Context, mapping *segmentMapping, attachmentId, segment string) error {
+	for _, route := range mapping.StaticRoutes {
+		if segment != "" && route.TargetSegment == segment {
+			if err := h.upsertRoute(ctx, route, attachmentId); err != nil {
+				return err
+			}
+			continue
+		}
+
+		current, err := h.routeAttachments(ctx, route)
+		if err != nil {
+			return err
+		}
+		if !current[attachmentId] {
+			continue
+		}
+		h.logger.Info("deleting static route", "attachment", attachmentId,
+			"routeTable", route.RouteTableId, "destination", route.DestinationCidr)
+		_, err = h.ec2.DeleteTransitGatewayRoute(ctx, &ec2.DeleteTransitGatewayRouteInput{
+			TransitGatewayRouteTableId: aws.String(route.RouteTableId),
+			DestinationCidrBlock:       aws.String(route.DestinationCidr),
+		})
+		if err != nil && errorCode(err) != "InvalidRoute.NotFound" {
+			return fmt.Errorf("deleting route %s in %s: %w", route.DestinationCidr, route.RouteTableId, err)
+		}
+	}
+	return nil
+}
+
+// Creating a route that already exists replaces it, so retries are
+// idempotent.
+func (h *handler) upsertRoute(ctx context.Context, route segmentStaticRoute, attachmentId string) error {
+	logger := h.logger.With("attachment", attachmentId, "routeTable", route.RouteTableId, "destination", route.DestinationCidr)
+	_, err := h.ec2.CreateTransitGatewayRoute(ctx, &ec2.CreateTransitGatewayRouteInput{
+		TransitGatewayRouteTableId: aws.String(route.RouteTableId),
+		DestinationCidrBlock:       aws.String(route.DestinationCidr),
+		TransitGatewayAttachmentId: aws.String(attachmentId),
+	})
+	if err == nil {
+		logger.Info("created static route")
+		return nil
+	}
+	if errorCode(err) != "RouteAlreadyExists" {
+		return fmt.Errorf("creating route %s in %s: %w", route.DestinationCidr, route.RouteTableId, err)
+	}
+
+	_, err = h.ec2.ReplaceTransitGatewayRoute(ctx, &ec2.ReplaceTransitGatewayRouteInput{
+		TransitGatewayRouteTableId: aws.String(route.RouteTableId),
+		DestinationCidrBlock:       aws.String(route.DestinationCidr),
+		TransitGatewayAttachmentId: aws.String(attachmentId),
+	})
+	if err != nil {
+		return fmt.Errorf("replacing route %s in %s: %w", route.DestinationCidr, route.RouteTableId, err)
+	}
+	logger.Info("replaced static route")
+	return nil
+}
+
+// Attachments the static route currently points at.
+func (h *handler) routeAttachments(ctx context.Context, route segmentStaticRoute) (map[string]bool, error) {
+	out, err := h.ec2.SearchTransitGatewayRoutes(ctx, &ec2.SearchTransitGatewayRoutesInput{
+		TransitGatewayRouteTableId: aws.String(route.RouteTableId),
+		Filters: []types.Filter{
+			{Name: aws.String("route-search.exact-match"), Values: []string{route.DestinationCidr}},
+			{Name: aws.String("type"), Values: []string{"static"}},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("searching route %s in %s: %w", route.DestinationCidr, route.RouteTableId, err)
+	}
+	attachments := map[string]bool{}
+	for _, found := range out.Routes {
+		for _, attachment := range found.TransitGatewayAttachments {
+			attachments[aws.ToString(attachment.TransitGatewayAttachmentId)] = true
+		}
+	}
+	return attachments, nil
+}
+
+func errorCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return ""
+}
+
+// Errors returned for attachments that are deleted or being deleted.
+func isGone(err error) bool {
+	switch errorCode(err) {
+	case "InvalidTransitGatewayAttachmentID.NotFound", "IncorrectState":
+		return true
+	}
+	return false
+}
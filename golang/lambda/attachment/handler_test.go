@@ -0,0 +1,272 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// Keeps the association and propagations of a single attachment and
+// records the calls changing them. A disassociation takes effect after one
+// describe, so the handler has to wait for it.
+type fakeEc2 struct {
+	attachment     *types.TransitGatewayAttachment
+	propagations   []string
+	disassociating bool
+	calls          []string
+}
+
+func (f *fakeEc2) DescribeTransitGatewayAttachments(ctx context.Context, params *ec2.DescribeTransitGatewayAttachmentsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeTransitGatewayAttachmentsOutput, error) {
+	if f.attachment == nil {
+		return &ec2.DescribeTransitGatewayAttachmentsOutput{}, nil
+	}
+	described := *f.attachment
+	if f.disassociating {
+		f.disassociating = false
+		f.attachment.Association = nil
+	}
+	return &ec2.DescribeTransitGatewayAttachmentsOutput{
+		TransitGatewayAttachments: []types.TransitGatewayAttachment{described},
+	}, nil
+}
+
+func (f *fakeEc2) AssociateTransitGatewayRouteTable(ctx context.Context, params *ec2.AssociateTransitGatewayRouteTableInput, optFns ...func(*ec2.Options)) (*ec2.AssociateTransitGatewayRouteTableOutput, error) {
+	f.calls = append(f.calls, "associate "+aws.ToString(params.TransitGatewayRouteTableId))
+	return &ec2.AssociateTransitGatewayRouteTableOutput{}, nil
+}
+
+func (f *fakeEc2) DisassociateTransitGatewayRouteTable(ctx context.Context, params *ec2.DisassociateTransitGatewayRouteTableInput, optFns ...func(*ec2.Options)) (*ec2.DisassociateTransitGatewayRouteTableOutput, error) {
+	f.calls = append(f.calls, "disassociate "+aws.ToString(params.TransitGatewayRouteTableId))
+	f.disassociating = true
+	return &ec2.DisassociateTransitGatewayRouteTableOutput{}, nil
+}
+
+func (f *fakeEc2) GetTransitGatewayAttachmentPropagations(ctx context.Context, params *ec2.GetTransitGatewayAttachmentPropagationsInput, optFns ...func(*ec2.Options)) (*ec2.GetTransitGatewayAttachmentPropagationsOutput, error) {
+	var propagations []types.TransitGatewayAttachmentPropagation
+	for _, id := range f.propagations {
+		propagations = append(propagations, types.TransitGatewayAttachmentPropagation{
+			TransitGatewayRouteTableId: aws.String(id),
+			State:                      types.TransitGatewayPropagationStateEnabled,
+		})
+	}
+	return &ec2.GetTransitGatewayAttachmentPropagationsOutput{TransitGatewayAttachmentPropagations: propagations}, nil
+}
+
+func (f *fakeEc2) EnableTransitGatewayRouteTablePropagation(ctx context.Context, params *ec2.EnableTransitGatewayRouteTablePropagationInput, optFns ...func(*ec2.Options)) (*ec2.EnableTransitGatewayRouteTablePropagationOutput, error) {
+	f.calls = append(f.calls, "enable "+aws.ToString(params.TransitGatewayRouteTableId))
+	return &ec2.EnableTransitGatewayRouteTablePropagationOutput{}, nil
+}
+
+func (f *fakeEc2) DisableTransitGatewayRouteTablePropagation(ctx context.Context, params *ec2.DisableTransitGatewayRouteTablePropagationInput, optFns ...func(*ec2.Options)) (*ec2.DisableTransitGatewayRouteTablePropagationOutput, error) {
+	f.calls = append(f.calls, "disable "+aws.ToString(params.TransitGatewayRouteTableId))
+	return &ec2.DisableTransitGatewayRouteTablePropagationOutput{}, nil
+}
+
+func (f *fakeEc2) CreateTransitGatewayRoute(ctx context.Context, params *ec2.CreateTransitGatewayRouteInput, optFns ...func(*ec2.Options)) (*ec2.CreateTransitGatewayRouteOutput, error) {
+	f.calls = append(f.calls, "route "+aws.ToString(params.TransitGatewayRouteTableId)+" "+aws.ToString(params.DestinationCidrBlock))
+	return &ec2.CreateTransitGatewayRouteOutput{}, nil
+}
+
+func (f *fakeEc2) ReplaceTransitGatewayRoute(ctx context.Context, params *ec2.ReplaceTransitGatewayRouteInput, optFns ...func(*ec2.Options)) (*ec2.ReplaceTransitGatewayRouteOutput, error) {
+	f.calls = append(f.calls, "replace "+aws.ToString(params.TransitGatewayRouteTableId)+" "+aws.ToString(params.DestinationCidrBlock))
+	return &ec2.ReplaceTransitGatewayRouteOutput{}, nil
+}
+
+func (f *fakeEc2) DeleteTransitGatewayRoute(ctx context.Context, params *ec2.DeleteTransitGatewayRouteInput, optFns ...func(*ec2.Options)) (*ec2.DeleteTransitGatewayRouteOutput, error) {
+	f.calls = append(f.calls, "delete "+aws.ToString(params.TransitGatewayRouteTableId)+" "+aws.ToString(params.DestinationCidrBlock))
+	return &ec2.DeleteTransitGatewayRouteOutput{}, nil
+}
+
+func (f *fakeEc2) SearchTransitGatewayRoutes(ctx context.Context, params *ec2.SearchTransitGatewayRoutesInput, optFns ...func(*ec2.Options)) (*ec2.SearchTransitGatewayRoutesOutput, error) {
+	return &ec2.SearchTransitGatewayRoutesOutput{}, nil
+}
+
+type fakeSsm struct {
+	value string
+}
+
+func (f *fakeSsm) GetParameter(ctx context.Context, params *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error) {
+	return &ssm.GetParameterOutput{Parameter: &ssmtypes.Parameter{Value: aws.String(f.value)}}, nil
+}
+
+const testMapping = `{
+	"quarantineRouteTableId": "tgw-rtb-quarantine",
+	"segments": {
+		"workload": {"associationRouteTableId": "tgw-rtb-workload", "propagationRouteTableIds": ["tgw-rtb-inspection"]},
+		"shared": {"associationRouteTableId": "tgw-rtb-shared", "propagationRouteTableIds": ["tgw-rtb-inspection", "tgw-rtb-workload"]}
+	},
+	"staticRoutes": [
+		{"routeTableId": "tgw-rtb-workload", "destinationCidr": "10.9.0.0/16", "targetSegment": "shared"}
+	]
+}`
+
+func vpcAttachment(routeTable, associatedWith string) *types.TransitGatewayAttachment {
+	attachment := &types.TransitGatewayAttachment{
+		TransitGatewayAttachmentId: aws.String("tgw-attach-1"),
+		ResourceType:               types.TransitGatewayAttachmentResourceTypeVpc,
+	}
+	if routeTable != "" {
+		attachment.Tags = []types.Tag{{Key: aws.String("routeTable"), Value: aws.String(routeTable)}}
+	}
+	if associatedWith != "" {
+		attachment.Association = &types.TransitGatewayAttachmentAssociation{
+			TransitGatewayRouteTableId: aws.String(associatedWith),
+			State:                      types.TransitGatewayAssociationStateAssociated,
+		}
+	}
+	return attachment
+}
+
+func tagsEvent(t *testing.T) events.CloudWatchEvent {
+	detail, err := json.Marshal(map[string]interface{}{
+		"eventName": "CreateTags",
+		"requestParameters": map[string]interface{}{
+			"resourcesSet": map[string]interface{}{
+				"items": []map[string]string{{"resourceId": "tgw-attach-1"}, {"resourceId": "vpc-1"}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events.CloudWatchEvent{ID: "event-1", Detail: detail}
+}
+
+func TestHandleTagChange(t *testing.T) {
+	tests := []struct {
+		name         string
+		attachment   *types.TransitGatewayAttachment
+		propagations []string
+		wantCalls    []string
+	}{
+		{
+			name:       "associates a new attachment",
+			attachment: vpcAttachment("workload", ""),
+			wantCalls:  []string{"associate tgw-rtb-workload", "enable tgw-rtb-inspection"},
+		},
+		{
+			name:         "keeps an attachment already in its segment",
+			attachment:   vpcAttachment("workload", "tgw-rtb-workload"),
+			propagations: []string{"tgw-rtb-inspection"},
+		},
+		{
+			name:         "moves an attachment to its new segment",
+			attachment:   vpcAttachment("shared", "tgw-rtb-workload"),
+			propagations: []string{"tgw-rtb-inspection"},
+			wantCalls: []string{
+				"disassociate tgw-rtb-workload",
+				"associate tgw-rtb-shared",
+				"enable tgw-rtb-workload",
+				"route tgw-rtb-workload 10.9.0.0/16",
+			},
+		},
+		{
+			name:         "quarantines an attachment with an unknown segment",
+			attachment:   vpcAttachment("unknown", "tgw-rtb-workload"),
+			propagations: []string{"tgw-rtb-inspection"},
+			wantCalls: []string{
+				"disassociate tgw-rtb-workload",
+				"associate tgw-rtb-quarantine",
+				"disable tgw-rtb-inspection",
+			},
+		},
+		{
+			name:       "quarantines an untagged attachment",
+			attachment: vpcAttachment("", ""),
+			wantCalls:  []string{"associate tgw-rtb-quarantine"},
+		},
+		{
+			name: "skips a deleted attachment",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ec2Client := &fakeEc2{attachment: tt.attachment, propagations: tt.propagations}
+			sleeps := 0
+			h := &handler{
+				ec2:               ec2Client,
+				ssm:               &fakeSsm{value: testMapping},
+				segmentsParameter: "/segments",
+				sleep:             func(time.Duration) { sleeps++ },
+				logger:            slog.New(slog.NewTextHandler(io.Discard, nil)),
+			}
+
+			if err := h.handle(context.Background(), tagsEvent(t)); err != nil {
+				t.Fatalf("handle error = %v", err)
+			}
+			if !slices.Equal(ec2Client.calls, tt.wantCalls) {
+				t.Errorf("calls = %q, want %q", ec2Client.calls, tt.wantCalls)
+			}
+			if slices.Contains(tt.wantCalls, "disassociate tgw-rtb-workload") && sleeps == 0 {
+				t.Error("did not wait for the disassociation")
+			}
+		})
+	}
+}
+
+func TestHandleSkipsStackManagedAttachments(t *testing.T) {
+	vpn := vpcAttachment("", "")
+	vpn.ResourceType = types.TransitGatewayAttachmentResourceTypeVpn
+
+	connect := vpcAttachment("", "tgw-rtb-sdwan")
+	connect.ResourceType = types.TransitGatewayAttachmentResourceTypeConnect
+
+	managed := vpcAttachment("", "tgw-rtb-sdwan")
+	managed.Tags = append(managed.Tags, types.Tag{Key: aws.String("associationManagedBy"), Value: aws.String("cloudformation")})
+
+	tests := []struct {
+		name       string
+		attachment *types.TransitGatewayAttachment
+	}{
+		{name: "VPN attachment", attachment: vpn},
+		{name: "Connect attachment", attachment: connect},
+		{name: "VPC attachment associated by its stack", attachment: managed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ec2Client := &fakeEc2{attachment: tt.attachment, propagations: []string{"tgw-rtb-inspection"}}
+			h := &handler{
+				ec2:               ec2Client,
+				ssm:               &fakeSsm{value: testMapping},
+				segmentsParameter: "/segments",
+				sleep:             func(time.Duration) {},
+				logger:            slog.New(slog.NewTextHandler(io.Discard, nil)),
+			}
+
+			if err := h.handle(context.Background(), tagsEvent(t)); err != nil {
+				t.Fatalf("handle error = %v", err)
+			}
+			if len(ec2Client.calls) > 0 {
+				t.Errorf("calls = %q, want none", ec2Client.calls)
+			}
+		})
+	}
+}
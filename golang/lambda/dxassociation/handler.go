@@ -0,0 +1,236 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/aws/aws-lambda-go/cfn"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/directconnect"
+	dxtypes "github.com/aws/aws-sdk-go-v2/service/directconnect/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+type DirectConnectAPI interface {
+	CreateDirectConnectGatewayAssociation(ctx context.Context, params *directconnect.CreateDirectConnectGatewayAssociationInput, optFns ...func(*directconnect.Options)) (*directconnect.CreateDirectConnectGatewayAssociationOutput, error)
+	UpdateDirectConnectGatewayAssociation(ctx context.Context, params *directconnect.UpdateDirectConnectGatewayAssociationInput, optFns ...func(*directconnect.Options)) (*directconnect.UpdateDirectConnectGatewayAssociationOutput, error)
+	DeleteDirectConnectGatewayAssociation(ctx context.Context, params *directconnect.DeleteDirectConnectGatewayAssociationInput, optFns ...func(*directconnect.Options)) (*directconnect.DeleteDirectConnectGatewayAssociationOutput, error)
+	DescribeDirectConnectGatewayAssociations(ctx context.Context, params *directconnect.DescribeDirectConnectGatewayAssociationsInput, optFns ...func(*directconnect.Options)) (*directconnect.DescribeDirectConnectGatewayAssociationsOutput, error)
+}
+
+type AttachmentAPI interface {
+	DescribeTransitGatewayAttachments(ctx context.Context, params *ec2.DescribeTransitGatewayAttachmentsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeTransitGatewayAttachmentsOutput, error)
+}
+
+type handler struct {
+	dx  DirectConnectAPI
+	ec2 AttachmentAPI
+}
+
+type onEventResponse struct {
+	PhysicalResourceId string `json:"PhysicalResourceId"`
+}
+
+// The provider framework merges the onEvent response into the event passed
+// to isComplete.
+type isCompleteEvent struct {
+	cfn.Event
+}
+
+type isCompleteResponse struct {
+	IsComplete bool              `json:"IsComplete"`
+	Data       map[string]string `json:"Data,omitempty"`
+}
+
+type associationProperties struct {
+	directConnectGatewayId string
+	transitGatewayId       string
+	allowedPrefixes        []string
+}
+
+func parseProperties(props map[string]interface{}) associationProperties {
+	p := associationProperties{}
+	p.directConnectGatewayId, _ = props["DirectConnectGatewayId"].(string)
+	p.transitGatewayId, _ = props["TransitGatewayId"].(string)
+	if prefixes, ok := props["AllowedPrefixes"].([]interface{}); ok {
+		for _, prefix := range prefixes {
+			p.allowedPrefixes = append(p.allowedPrefixes, fmt.Sprint(prefix))
+		}
+	}
+	return p
+}
+
+func routeFilterPrefixes(cidrs []string) []dxtypes.RouteFilterPrefix {
+	prefixes := make([]dxtypes.RouteFilterPrefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		prefixes = append(prefixes, dxtypes.RouteFilterPrefix{Cidr: aws.String(cidr)})
+	}
+	return prefixes
+}
+
+// Prefixes in a that are not in b.
+func difference(a, b []string) []string {
+	in := map[string]bool{}
+	for _, prefix := range b {
+		in[prefix] = true
+	}
+	var out []string
+	for _, prefix := range a {
+		if !in[prefix] {
+			out = append(out, prefix)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Starts creating, updating or deleting the association. isComplete waits
+// for it to settle. The association ID is the physical ID.
+func (h *handler) onEvent(ctx context.Context, event cfn.Event) (onEventResponse, error) {
+	log.Printf("%s request for %s", event.RequestType, event.LogicalResourceID)
+	props := parseProperties(event.ResourceProperties)
+
+	switch event.RequestType {
+	case cfn.RequestCreate:
+		return h.create(ctx, props)
+
+	case cfn.RequestUpdate:
+		old := parseProperties(event.OldResourceProperties)
+		// Another gateway is a new association. CloudFormation deletes the
+		// old one once the update has completed.
+		if old.directConnectGatewayId != props.directConnectGatewayId || old.transitGatewayId != props.transitGatewayId {
+			return h.create(ctx, props)
+		}
+
+		add := difference(props.allowedPrefixes, old.allowedPrefixes)
+		remove := difference(old.allowedPrefixes, props.allowedPrefixes)
+		if len(add) > 0 || len(remove) > 0 {
+			log.Printf("Updating allowed prefixes of %s: adding %v, removing %v", event.PhysicalResourceID, add, remove)
+			_, err := h.dx.UpdateDirectConnectGatewayAssociation(ctx, &directconnect.UpdateDirectConnectGatewayAssociationInput{
+				AssociationId:                               aws.String(event.PhysicalResourceID),
+				AddAllowedPrefixesToDirectConnectGateway:    routeFilterPrefixes(add),
+				RemoveAllowedPrefixesToDirectConnectGateway: routeFilterPrefixes(remove),
+			})
+			if err != nil {
+				return onEventResponse{}, fmt.Errorf("updating association %s: %w", event.PhysicalResourceID, err)
+			}
+		}
+		return onEventResponse{PhysicalResourceId: event.PhysicalResourceID}, nil
+
+	case cfn.RequestDelete:
+		association, err := h.describe(ctx, event.PhysicalResourceID)
+		if err != nil {
+			return onEventResponse{}, err
+		}
+		if association == nil || association.AssociationState == dxtypes.DirectConnectGatewayAssociationStateDisassociated {
+			log.Printf("Association %s already deleted", event.PhysicalResourceID)
+			return onEventResponse{PhysicalResourceId: event.PhysicalResourceID}, nil
+		}
+		_, err = h.dx.DeleteDirectConnectGatewayAssociation(ctx, &directconnect.DeleteDirectConnectGatewayAssociationInput{
+			AssociationId: aws.String(event.PhysicalResourceID),
+		})
+		if err != nil {
+			return onEventResponse{}, fmt.Errorf("deleting association %s: %w", event.PhysicalResourceID, err)
+		}
+		return onEventResponse{PhysicalResourceId: event.PhysicalResourceID}, nil
+	}
+
+	return onEventResponse{}, fmt.Errorf("unknown request type %q", event.RequestType)
+}
+
+func (h *handler) create(ctx context.Context, props associationProperties) (onEventResponse, error) {
+	out, err := h.dx.CreateDirectConnectGatewayAssociation(ctx, &directconnect.CreateDirectConnectGatewayAssociationInput{
+		DirectConnectGatewayId:                   aws.String(props.directConnectGatewayId),
+		GatewayId:                                aws.String(props.transitGatewayId),
+		AddAllowedPrefixesToDirectConnectGateway: routeFilterPrefixes(props.allowedPrefixes),
+	})
+	if err != nil {
+		return onEventResponse{}, fmt.Errorf("associating %s with %s: %w", props.directConnectGatewayId, props.transitGatewayId, err)
+	}
+	associationId := aws.ToString(out.DirectConnectGatewayAssociation.AssociationId)
+	log.Printf("Associating %s with %s as %s", props.directConnectGatewayId, props.transitGatewayId, associationId)
+	return onEventResponse{PhysicalResourceId: associationId}, nil
+}
+
+// Waits for the association to settle. Once associated, returns the transit
+// gateway attachment of the Direct Connect gateway.
+func (h *handler) isComplete(ctx context.Context, event isCompleteEvent) (isCompleteResponse, error) {
+	association, err := h.describe(ctx, event.PhysicalResourceID)
+	if err != nil {
+		return isCompleteResponse{}, err
+	}
+
+	if event.RequestType == cfn.RequestDelete {
+		if association == nil || association.AssociationState == dxtypes.DirectConnectGatewayAssociationStateDisassociated {
+			return isCompleteResponse{IsComplete: true}, nil
+		}
+		log.Printf("Waiting for association %s to be deleted (%s)", event.PhysicalResourceID, association.AssociationState)
+		return isCompleteResponse{IsComplete: false}, nil
+	}
+
+	if association == nil {
+		return isCompleteResponse{}, fmt.Errorf("association %s not found", event.PhysicalResourceID)
+	}
+	switch association.AssociationState {
+	case dxtypes.DirectConnectGatewayAssociationStateAssociated:
+	case dxtypes.DirectConnectGatewayAssociationStateAssociating, dxtypes.DirectConnectGatewayAssociationStateUpdating:
+		log.Printf("Waiting for association %s (%s)", event.PhysicalResourceID, association.AssociationState)
+		return isCompleteResponse{IsComplete: false}, nil
+	default:
+		return isCompleteResponse{}, fmt.Errorf("association %s is %s: %s", event.PhysicalResourceID,
+			association.AssociationState, aws.ToString(association.StateChangeError))
+	}
+
+	props := parseProperties(event.ResourceProperties)
+	out, err := h.ec2.DescribeTransitGatewayAttachments(ctx, &ec2.DescribeTransitGatewayAttachmentsInput{
+		Filters: []ec2types.Filter{
+			{Name: aws.String("transit-gateway-id"), Values: []string{props.transitGatewayId}},
+			{Name: aws.String("resource-id"), Values: []string{props.directConnectGatewayId}},
+			{Name: aws.String("resource-type"), Values: []string{"direct-connect-gateway"}},
+		},
+	})
+	if err != nil {
+		return isCompleteResponse{}, fmt.Errorf("describing attachment of %s: %w", props.directConnectGatewayId, err)
+	}
+	for _, attachment := range out.TransitGatewayAttachments {
+		if attachment.State == ec2types.TransitGatewayAttachmentStateAvailable {
+			return isCompleteResponse{
+				IsComplete: true,
+				Data:       map[string]string{"TransitGatewayAttachmentId": aws.ToString(attachment.TransitGatewayAttachmentId)},
+			}, nil
+		}
+	}
+	log.Printf("Waiting for the transit gateway attachment of %s", props.directConnectGatewayId)
+	return isCompleteResponse{IsComplete: false}, nil
+}
+
+func (h *handler) describe(ctx context.Context, associationId string) (*dxtypes.DirectConnectGatewayAssociation, error) {
+	out, err := h.dx.DescribeDirectConnectGatewayAssociations(ctx, &directconnect.DescribeDirectConnectGatewayAssociationsInput{
+		AssociationId: aws.String(associationId),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("describing association %s: %w", associationId, err)
+	}
+	if len(out.DirectConnectGatewayAssociations) == 0 {
+		return nil, nil
+	}
+	return &out.DirectConnectGatewayAssociations[0], nil
+}
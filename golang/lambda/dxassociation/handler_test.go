@@ -0,0 +1,330 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/cfn"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/directconnect"
+	dxtypes "github.com/aws/aws-sdk-go-v2/service/directconnect/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Returns the given association, if any, and records the calls changing
+// associations.
+type fakeDx struct {
+	association *dxtypes.DirectConnectGatewayAssociation
+	calls       []string
+}
+
+func prefixes(filters []dxtypes.RouteFilterPrefix) string {
+	var cidrs []string
+	for _, filter := range filters {
+		cidrs = append(cidrs, aws.ToString(filter.Cidr))
+	}
+	return "[" + strings.Join(cidrs, " ") + "]"
+}
+
+func (f *fakeDx) CreateDirectConnectGatewayAssociation(ctx context.Context, params *directconnect.CreateDirectConnectGatewayAssociationInput, optFns ...func(*directconnect.Options)) (*directconnect.CreateDirectConnectGatewayAssociationOutput, error) {
+	f.calls = append(f.calls, "create "+aws.ToString(params.DirectConnectGatewayId)+" "+aws.ToString(params.GatewayId)+" "+prefixes(params.AddAllowedPrefixesToDirectConnectGateway))
+	return &directconnect.CreateDirectConnectGatewayAssociationOutput{
+		DirectConnectGatewayAssociation: &dxtypes.DirectConnectGatewayAssociation{AssociationId: aws.String("assoc-new")},
+	}, nil
+}
+
+func (f *fakeDx) UpdateDirectConnectGatewayAssociation(ctx context.Context, params *directconnect.UpdateDirectConnectGatewayAssociationInput, optFns ...func(*directconnect.Options)) (*directconnect.UpdateDirectConnectGatewayAssociationOutput, error) {
+	f.calls = append(f.calls, "update "+aws.ToString(params.AssociationId)+" add "+prefixes(params.AddAllowedPrefixesToDirectConnectGateway)+" remove "+prefixes(params.RemoveAllowedPrefixesToDirectConnectGateway))
+	return &directconnect.UpdateDirectConnectGatewayAssociationOutput{}, nil
+}
+
+func (f *fakeDx) DeleteDirectConnectGatewayAssociation(ctx context.Context, params *directconnect.DeleteDirectConnectGatewayAssociationInput, optFns ...func(*directconnect.Options)) (*directconnect.DeleteDirectConnectGatewayAssociationOutput, error) {
+	f.calls = append(f.calls, "delete "+aws.ToString(params.AssociationId))
+	return &directconnect.DeleteDirectConnectGatewayAssociationOutput{}, nil
+}
+
+func (f *fakeDx) DescribeDirectConnectGatewayAssociations(ctx context.Context, params *directconnect.DescribeDirectConnectGatewayAssociationsInput, optFns ...func(*directconnect.Options)) (*directconnect.DescribeDirectConnectGatewayAssociationsOutput, error) {
+	out := &directconnect.DescribeDirectConnectGatewayAssociationsOutput{}
+	if f.association != nil {
+		out.DirectConnectGatewayAssociations = []dxtypes.DirectConnectGatewayAssociation{*f.association}
+	}
+	return out, nil
+}
+
+type fakeEc2 struct {
+	attachments []ec2types.TransitGatewayAttachment
+}
+
+func (f *fakeEc2) DescribeTransitGatewayAttachments(ctx context.Context, params *ec2.DescribeTransitGatewayAttachmentsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeTransitGatewayAttachmentsOutput, error) {
+	return &ec2.DescribeTransitGatewayAttachmentsOutput{TransitGatewayAttachments: f.attachments}, nil
+}
+
+func associationProps(gatewayId string, allowedPrefixes ...string) map[string]interface{} {
+	var prefixes []interface{}
+	for _, prefix := range allowedPrefixes {
+		prefixes = append(prefixes, prefix)
+	}
+	return map[string]interface{}{
+		"DirectConnectGatewayId": gatewayId,
+		"TransitGatewayId":       "tgw-1",
+		"AllowedPrefixes":        prefixes,
+	}
+}
+
+func association(state dxtypes.DirectConnectGatewayAssociationState) *dxtypes.DirectConnectGatewayAssociation {
+	return &dxtypes.DirectConnectGatewayAssociation{
+		AssociationId:    aws.String("assoc-1"),
+		AssociationState: state,
+		StateChangeError: aws.String("prefix overlaps"),
+	}
+}
+
+func TestDifference(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []string
+		want []string
+	}{
+		{name: "same prefixes", a: []string{"10.0.0.0/8"}, b: []string{"10.0.0.0/8"}},
+		{name: "added prefixes are sorted", a: []string{"10.2.0.0/16", "10.0.0.0/8", "10.1.0.0/16"}, b: []string{"10.0.0.0/8"}, want: []string{"10.1.0.0/16", "10.2.0.0/16"}},
+		{name: "nothing to compare with", a: []string{"10.0.0.0/8"}, want: []string{"10.0.0.0/8"}},
+		{name: "nothing left", b: []string{"10.0.0.0/8"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := difference(tt.a, tt.b); !slices.Equal(got, tt.want) {
+				t.Errorf("difference = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOnEvent(t *testing.T) {
+	tests := []struct {
+		name        string
+		event       cfn.Event
+		association *dxtypes.DirectConnectGatewayAssociation
+		wantId      string
+		wantCalls   []string
+	}{
+		{
+			name: "create",
+			event: cfn.Event{
+				RequestType:        cfn.RequestCreate,
+				ResourceProperties: associationProps("dxgw-1", "10.0.0.0/8"),
+			},
+			wantId:    "assoc-new",
+			wantCalls: []string{"create dxgw-1 tgw-1 [10.0.0.0/8]"},
+		},
+		{
+			name: "update of the allowed prefixes",
+			event: cfn.Event{
+				RequestType:           cfn.RequestUpdate,
+				PhysicalResourceID:    "assoc-1",
+				ResourceProperties:    associationProps("dxgw-1", "10.0.0.0/8", "172.16.0.0/12"),
+				OldResourceProperties: associationProps("dxgw-1", "10.0.0.0/8", "192.168.0.0/16"),
+			},
+			wantId:    "assoc-1",
+			wantCalls: []string{"update assoc-1 add [172.16.0.0/12] remove [192.168.0.0/16]"},
+		},
+		{
+			name: "update without changes",
+			event: cfn.Event{
+				RequestType:           cfn.RequestUpdate,
+				PhysicalResourceID:    "assoc-1",
+				ResourceProperties:    associationProps("dxgw-1", "10.0.0.0/8"),
+				OldResourceProperties: associationProps("dxgw-1", "10.0.0.0/8"),
+			},
+			wantId: "assoc-1",
+		},
+		{
+			name: "update to another gateway creates a new association",
+			event: cfn.Event{
+				RequestType:           cfn.RequestUpdate,
+				PhysicalResourceID:    "assoc-1",
+				ResourceProperties:    associationProps("dxgw-2", "10.0.0.0/8"),
+				OldResourceProperties: associationProps("dxgw-1", "10.0.0.0/8"),
+			},
+			wantId:    "assoc-new",
+			wantCalls: []string{"create dxgw-2 tgw-1 [10.0.0.0/8]"},
+		},
+		{
+			name: "delete",
+			event: cfn.Event{
+				RequestType:        cfn.RequestDelete,
+				PhysicalResourceID: "assoc-1",
+				ResourceProperties: associationProps("dxgw-1", "10.0.0.0/8"),
+			},
+			association: association(dxtypes.DirectConnectGatewayAssociationStateAssociated),
+			wantId:      "assoc-1",
+			wantCalls:   []string{"delete assoc-1"},
+		},
+		{
+			name: "delete of a missing association",
+			event: cfn.Event{
+				RequestType:        cfn.RequestDelete,
+				PhysicalResourceID: "assoc-1",
+				ResourceProperties: associationProps("dxgw-1", "10.0.0.0/8"),
+			},
+			wantId: "assoc-1",
+		},
+		{
+			name: "delete of a disassociated association",
+			event: cfn.Event{
+				RequestType:        cfn.RequestDelete,
+				PhysicalResourceID: "assoc-1",
+				ResourceProperties: associationProps("dxgw-1", "10.0.0.0/8"),
+			},
+			association: association(dxtypes.DirectConnectGatewayAssociationStateDisassociated),
+			wantId:      "assoc-1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dx := &fakeDx{association: tt.association}
+			h := &handler{dx: dx, ec2: &fakeEc2{}}
+
+			resp, err := h.onEvent(context.Background(), tt.event)
+			if err != nil {
+				t.Fatalf("onEvent error = %v", err)
+			}
+			if resp.PhysicalResourceId != tt.wantId {
+				t.Errorf("PhysicalResourceId = %q, want %q", resp.PhysicalResourceId, tt.wantId)
+			}
+			if !slices.Equal(dx.calls, tt.wantCalls) {
+				t.Errorf("calls = %q, want %q", dx.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestIsComplete(t *testing.T) {
+	available := ec2types.TransitGatewayAttachment{
+		TransitGatewayAttachmentId: aws.String("tgw-attach-dx"),
+		State:                      ec2types.TransitGatewayAttachmentStateAvailable,
+	}
+	pending := ec2types.TransitGatewayAttachment{
+		TransitGatewayAttachmentId: aws.String("tgw-attach-dx"),
+		State:                      ec2types.TransitGatewayAttachmentStatePending,
+	}
+
+	tests := []struct {
+		name           string
+		requestType    cfn.RequestType
+		association    *dxtypes.DirectConnectGatewayAssociation
+		attachments    []ec2types.TransitGatewayAttachment
+		wantComplete   bool
+		wantAttachment string
+		wantErr        string
+	}{
+		{
+			name:        "waits while associating",
+			requestType: cfn.RequestCreate,
+			association: association(dxtypes.DirectConnectGatewayAssociationStateAssociating),
+		},
+		{
+			name:        "waits while updating",
+			requestType: cfn.RequestUpdate,
+			association: association(dxtypes.DirectConnectGatewayAssociationStateUpdating),
+		},
+		{
+			name:        "waits for the attachment to be available",
+			requestType: cfn.RequestCreate,
+			association: association(dxtypes.DirectConnectGatewayAssociationStateAssociated),
+			attachments: []ec2types.TransitGatewayAttachment{pending},
+		},
+		{
+			name:        "waits for the attachment to show up",
+			requestType: cfn.RequestCreate,
+			association: association(dxtypes.DirectConnectGatewayAssociationStateAssociated),
+		},
+		{
+			name:           "returns the available attachment",
+			requestType:    cfn.RequestCreate,
+			association:    association(dxtypes.DirectConnectGatewayAssociationStateAssociated),
+			attachments:    []ec2types.TransitGatewayAttachment{available},
+			wantComplete:   true,
+			wantAttachment: "tgw-attach-dx",
+		},
+		{
+			name:        "fails on a disassociated association",
+			requestType: cfn.RequestCreate,
+			association: association(dxtypes.DirectConnectGatewayAssociationStateDisassociated),
+			wantErr:     "prefix overlaps",
+		},
+		{
+			name:        "fails on a disassociating association",
+			requestType: cfn.RequestUpdate,
+			association: association(dxtypes.DirectConnectGatewayAssociationStateDisassociating),
+			wantErr:     "prefix overlaps",
+		},
+		{
+			name:        "fails on a missing association",
+			requestType: cfn.RequestCreate,
+			wantErr:     "not found",
+		},
+		{
+			name:        "waits while disassociating on delete",
+			requestType: cfn.RequestDelete,
+			association: association(dxtypes.DirectConnectGatewayAssociationStateDisassociating),
+		},
+		{
+			name:         "delete completes once disassociated",
+			requestType:  cfn.RequestDelete,
+			association:  association(dxtypes.DirectConnectGatewayAssociationStateDisassociated),
+			wantComplete: true,
+		},
+		{
+			name:         "delete completes once gone",
+			requestType:  cfn.RequestDelete,
+			wantComplete: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &handler{dx: &fakeDx{association: tt.association}, ec2: &fakeEc2{attachments: tt.attachments}}
+
+			resp, err := h.isComplete(context.Background(), isCompleteEvent{Event: cfn.Event{
+				RequestType:        tt.requestType,
+				PhysicalResourceID: "assoc-1",
+				ResourceProperties: associationProps("dxgw-1", "10.0.0.0/8"),
+			}})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("isComplete error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("isComplete error = %v", err)
+			}
+			if resp.IsComplete != tt.wantComplete {
+				t.Errorf("IsComplete = %v, want %v", resp.IsComplete, tt.wantComplete)
+			}
+			if resp.Data["TransitGatewayAttachmentId"] != tt.wantAttachment {
+				t.Errorf("TransitGatewayAttachmentId = %q, want %q", resp.Data["TransitGatewayAttachmentId"], tt.wantAttachment)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/cfn"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+type AlarmAPI interface {
+	DescribeAlarms(ctx context.Context, params *cloudwatch.DescribeAlarmsInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.DescribeAlarmsOutput, error)
+}
+
+type StackAPI interface {
+	DescribeStacks(ctx context.Context, params *cloudformation.DescribeStacksInput, optFns ...func(*cloudformation.Options)) (*cloudformation.DescribeStacksOutput, error)
+}
+
+type handler struct {
+	cloudwatch     AlarmAPI
+	cloudformation StackAPI
+	now            func() time.Time
+}
+
+type onEventResponse struct {
+	PhysicalResourceId string            `json:"PhysicalResourceId"`
+	Data               map[string]string `json:"Data,omitempty"`
+}
+
+// The provider framework merges the onEvent response into the event passed
+// to isComplete.
+type isCompleteEvent struct {
+	cfn.Event
+	Data map[string]string `json:"Data"`
+}
+
+type isCompleteResponse struct {
+	IsComplete bool `json:"IsComplete"`
+}
+
+// Records when the firewall policy was switched. The health check only
+// waits when an update switches the firewall to another policy. A rollback
+// returns to the last healthy policy and must not be held up, or fail, by
+// the alarms of the policy that is rolled back.
+func (h *handler) onEvent(ctx context.Context, event cfn.Event) (onEventResponse, error) {
+	log.Printf("%s request for %s", event.RequestType, event.LogicalResourceID)
+	if event.RequestType == cfn.RequestDelete {
+		return onEventResponse{PhysicalResourceId: event.PhysicalResourceID}, nil
+	}
+
+	firewallArn, _ := event.ResourceProperties["FirewallArn"].(string)
+	wait := false
+	if event.RequestType == cfn.RequestUpdate {
+		policyArn, _ := event.ResourceProperties["FirewallPolicyArn"].(string)
+		oldPolicyArn, _ := event.OldResourceProperties["FirewallPolicyArn"].(string)
+		if policyArn != oldPolicyArn {
+			rollback, err := h.rollingBack(ctx, event.StackID)
+			if err != nil {
+				return onEventResponse{}, err
+			}
+			wait = !rollback
+			log.Printf("Firewall policy switched from %s to %s, rollback: %t", oldPolicyArn, policyArn, rollback)
+		}
+	}
+
+	return onEventResponse{
+		PhysicalResourceId: firewallArn,
+		Data: map[string]string{
+			"StartTime": h.now().UTC().Format(time.RFC3339),
+			"Wait":      strconv.FormatBool(wait),
+		},
+	}, nil
+}
+
+func (h *handler) rollingBack(ctx context.Context, stackId string) (bool, error) {
+	out, err := h.cloudformation.DescribeStacks(ctx, &cloudformation.DescribeStacksInput{
+		StackName: aws.String(stackId),
+	})
+	if err != nil {
+		return false, fmt.Errorf("describing stack %s: %w", stackId, err)
+	}
+	if len(out.Stacks) == 0 {
+		return false, fmt.Errorf("stack %s not found", stackId)
+	}
+	return strings.Contains(string(out.Stacks[0].StackStatus), "ROLLBACK"), nil
+}
+
+// Fails the deployment if a health check alarm fired after the switch.
+func (h *handler) isComplete(ctx context.Context, event isCompleteEvent) (isCompleteResponse, error) {
+	if event.RequestType == cfn.RequestDelete || event.Data["Wait"] != "true" {
+		return isCompleteResponse{IsComplete: true}, nil
+	}
+
+	startTime, err := time.Parse(time.RFC3339, event.Data["StartTime"])
+	if err != nil {
+		return isCompleteResponse{}, fmt.Errorf("parsing start time: %w", err)
+	}
+
+	monitoringMinutes, err := strconv.ParseFloat(fmt.Sprint(event.ResourceProperties["MonitoringMinutes"]), 64)
+	if err != nil {
+		return isCompleteResponse{}, fmt.Errorf("parsing monitoring minutes: %w", err)
+	}
+
+	var alarmNames []string
+	if names, ok := event.ResourceProperties["AlarmNames"].([]interface{}); ok {
+		for _, name := range names {
+			alarmNames = append(alarmNames, fmt.Sprint(name))
+		}
+	}
+
+	out, err := h.cloudwatch.DescribeAlarms(ctx, &cloudwatch.DescribeAlarmsInput{
+		AlarmNames: alarmNames,
+		AlarmTypes: []types.AlarmType{types.AlarmTypeMetricAlarm, types.AlarmTypeCompositeAlarm},
+	})
+	if err != nil {
+		return isCompleteResponse{}, fmt.Errorf("describing alarms: %w", err)
+	}
+
+	// Only alarms that went into ALARM after the switch count. Alarms that
+	// were already firing must not block the rollback to the previous policy.
+	var failed []string
+	for _, alarm := range out.MetricAlarms {
+		if alarm.StateValue == types.StateValueAlarm && aws.ToTime(alarm.StateUpdatedTimestamp).After(startTime) {
+			failed = append(failed, aws.ToString(alarm.AlarmName))
+		}
+	}
+	for _, alarm := range out.CompositeAlarms {
+		if alarm.StateValue == types.StateValueAlarm && aws.ToTime(alarm.StateUpdatedTimestamp).After(startTime) {
+			failed = append(failed, aws.ToString(alarm.AlarmName))
+		}
+	}
+	if len(failed) > 0 {
+		return isCompleteResponse{}, fmt.Errorf("firewall policy %v failed its health check, alarms in ALARM: %s",
+			event.ResourceProperties["FirewallPolicyArn"], strings.Join(failed, ", "))
+	}
+
+	elapsed := h.now().Sub(startTime)
+	monitoring := time.Duration(monitoringMinutes * float64(time.Minute))
+	log.Printf("Health check passing, %s of %s elapsed", elapsed.Round(time.Second), monitoring)
+	return isCompleteResponse{IsComplete: elapsed >= monitoring}, nil
+}
@@ -0,0 +1,227 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/cfn"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	cfntypes "github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+type fakeCloudformation struct {
+	status cfntypes.StackStatus
+}
+
+func (f *fakeCloudformation) DescribeStacks(ctx context.Context, params *cloudformation.DescribeStacksInput, optFns ...func(*cloudformation.Options)) (*cloudformation.DescribeStacksOutput, error) {
+	return &cloudformation.DescribeStacksOutput{
+		Stacks: []cfntypes.Stack{{StackId: params.StackName, StackStatus: f.status}},
+	}, nil
+}
+
+type fakeCloudwatch struct {
+	metricAlarms    []types.MetricAlarm
+	compositeAlarms []types.CompositeAlarm
+}
+
+func (f *fakeCloudwatch) DescribeAlarms(ctx context.Context, params *cloudwatch.DescribeAlarmsInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.DescribeAlarmsOutput, error) {
+	return &cloudwatch.DescribeAlarmsOutput{MetricAlarms: f.metricAlarms, CompositeAlarms: f.compositeAlarms}, nil
+}
+
+func TestIsCompleteAlarmWindow(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name            string
+		elapsed         time.Duration
+		metricAlarms    []types.MetricAlarm
+		compositeAlarms []types.CompositeAlarm
+		wantComplete    bool
+		wantErr         bool
+	}{
+		{
+			name:    "waits while the monitoring window is open",
+			elapsed: 5 * time.Minute,
+			metricAlarms: []types.MetricAlarm{
+				{AlarmName: aws.String("drops"), StateValue: types.StateValueOk, StateUpdatedTimestamp: aws.Time(start.Add(time.Minute))},
+			},
+		},
+		{
+			name:         "completes once the monitoring window has passed",
+			elapsed:      10 * time.Minute,
+			wantComplete: true,
+		},
+		{
+			name:    "ignores alarms that fired before the switch",
+			elapsed: 10 * time.Minute,
+			metricAlarms: []types.MetricAlarm{
+				{AlarmName: aws.String("drops"), StateValue: types.StateValueAlarm, StateUpdatedTimestamp: aws.Time(start.Add(-time.Minute))},
+			},
+			wantComplete: true,
+		},
+		{
+			name:    "fails on a metric alarm that fired after the switch",
+			elapsed: 2 * time.Minute,
+			metricAlarms: []types.MetricAlarm{
+				{AlarmName: aws.String("drops"), StateValue: types.StateValueAlarm, StateUpdatedTimestamp: aws.Time(start.Add(time.Minute))},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "fails on a composite alarm that fired after the switch",
+			elapsed: 2 * time.Minute,
+			compositeAlarms: []types.CompositeAlarm{
+				{AlarmName: aws.String("health"), StateValue: types.StateValueAlarm, StateUpdatedTimestamp: aws.Time(start.Add(time.Minute))},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &handler{
+				cloudwatch: &fakeCloudwatch{metricAlarms: tt.metricAlarms, compositeAlarms: tt.compositeAlarms},
+				now:        func() time.Time { return start.Add(tt.elapsed) },
+			}
+
+			resp, err := h.isComplete(context.Background(), isCompleteEvent{
+				Event: cfn.Event{
+					RequestType: cfn.RequestUpdate,
+					ResourceProperties: map[string]interface{}{
+						"FirewallPolicyArn": "arn:aws:network-firewall:eu-west-1:111111111111:firewall-policy/green",
+						"MonitoringMinutes": "10",
+						"AlarmNames":        []interface{}{"drops", "health"},
+					},
+				},
+				Data: map[string]string{"StartTime": start.Format(time.RFC3339), "Wait": "true"},
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("isComplete error = %v, want error %v", err, tt.wantErr)
+			}
+			if resp.IsComplete != tt.wantComplete {
+				t.Errorf("IsComplete = %v, want %v", resp.IsComplete, tt.wantComplete)
+			}
+		})
+	}
+}
+
+func TestIsCompleteDelete(t *testing.T) {
+	h := &handler{cloudwatch: &fakeCloudwatch{}, now: time.Now}
+	resp, err := h.isComplete(context.Background(), isCompleteEvent{Event: cfn.Event{RequestType: cfn.RequestDelete}})
+	if err != nil || !resp.IsComplete {
+		t.Errorf("isComplete = %v, %v, want complete", resp, err)
+	}
+}
+
+func TestOnEventWaitsOnlyForSwitches(t *testing.T) {
+	props := func(policy string) map[string]interface{} {
+		return map[string]interface{}{
+			"FirewallArn":       "arn:aws:network-firewall:eu-west-1:111111111111:firewall/fw",
+			"FirewallPolicyArn": "arn:aws:network-firewall:eu-west-1:111111111111:firewall-policy/" + policy,
+		}
+	}
+
+	tests := []struct {
+		name        string
+		requestType cfn.RequestType
+		oldPolicy   string
+		newPolicy   string
+		stackStatus cfntypes.StackStatus
+		wantWait    string
+	}{
+		{
+			name:        "create",
+			requestType: cfn.RequestCreate,
+			newPolicy:   "blue",
+			stackStatus: cfntypes.StackStatusCreateInProgress,
+			wantWait:    "false",
+		},
+		{
+			name:        "update keeping the policy",
+			requestType: cfn.RequestUpdate,
+			oldPolicy:   "blue",
+			newPolicy:   "blue",
+			stackStatus: cfntypes.StackStatusUpdateInProgress,
+			wantWait:    "false",
+		},
+		{
+			name:        "switch to another policy",
+			requestType: cfn.RequestUpdate,
+			oldPolicy:   "blue",
+			newPolicy:   "green",
+			stackStatus: cfntypes.StackStatusUpdateInProgress,
+			wantWait:    "true",
+		},
+		{
+			name:        "rollback to the previous policy",
+			requestType: cfn.RequestUpdate,
+			oldPolicy:   "green",
+			newPolicy:   "blue",
+			stackStatus: cfntypes.StackStatusUpdateRollbackInProgress,
+			wantWait:    "false",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &handler{
+				cloudwatch:     &fakeCloudwatch{},
+				cloudformation: &fakeCloudformation{status: tt.stackStatus},
+				now:            time.Now,
+			}
+			event := cfn.Event{
+				RequestType:        tt.requestType,
+				StackID:            "arn:aws:cloudformation:eu-west-1:111111111111:stack/Inspection/1",
+				ResourceProperties: props(tt.newPolicy),
+			}
+			if tt.oldPolicy != "" {
+				event.OldResourceProperties = props(tt.oldPolicy)
+			}
+
+			resp, err := h.onEvent(context.Background(), event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Data["Wait"] != tt.wantWait {
+				t.Errorf("Wait = %q, want %q", resp.Data["Wait"], tt.wantWait)
+			}
+		})
+	}
+}
+
+func TestIsCompleteWithoutWait(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	h := &handler{
+		cloudwatch: &fakeCloudwatch{metricAlarms: []types.MetricAlarm{
+			{AlarmName: aws.String("drops"), StateValue: types.StateValueAlarm, StateUpdatedTimestamp: aws.Time(start.Add(time.Minute))},
+		}},
+		now: func() time.Time { return start.Add(2 * time.Minute) },
+	}
+
+	resp, err := h.isComplete(context.Background(), isCompleteEvent{
+		Event: cfn.Event{RequestType: cfn.RequestUpdate},
+		Data:  map[string]string{"StartTime": start.Format(time.RFC3339), "Wait": "false"},
+	})
+	if err != nil || !resp.IsComplete {
+		t.Errorf("isComplete = %v, %v, want complete at once", resp, err)
+	}
+}
@@ -0,0 +1,303 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/cfn"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/networkfirewall"
+	"github.com/aws/aws-sdk-go-v2/service/networkfirewall/types"
+	"github.com/aws/smithy-go"
+)
+
+type FirewallAPI interface {
+	DescribeFirewall(ctx context.Context, params *networkfirewall.DescribeFirewallInput, optFns ...func(*networkfirewall.Options)) (*networkfirewall.DescribeFirewallOutput, error)
+}
+
+type RouteAPI interface {
+	CreateRoute(ctx context.Context, params *ec2.CreateRouteInput, optFns ...func(*ec2.Options)) (*ec2.CreateRouteOutput, error)
+	ReplaceRoute(ctx context.Context, params *ec2.ReplaceRouteInput, optFns ...func(*ec2.Options)) (*ec2.ReplaceRouteOutput, error)
+	DeleteRoute(ctx context.Context, params *ec2.DeleteRouteInput, optFns ...func(*ec2.Options)) (*ec2.DeleteRouteOutput, error)
+	DescribeAvailabilityZones(ctx context.Context, params *ec2.DescribeAvailabilityZonesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeAvailabilityZonesOutput, error)
+}
+
+type handler struct {
+	nfw FirewallAPI
+	ec2 RouteAPI
+	now func() time.Time
+}
+
+// Response returned to the custom resource provider framework.
+type response struct {
+	PhysicalResourceId string                 `json:"PhysicalResourceId"`
+	Data               map[string]interface{} `json:"Data,omitempty"`
+}
+
+type routeProperties struct {
+	firewallArn     string
+	subnetAz        string
+	subnetAzId      string
+	routeTableId    string
+	destinationCidr string
+}
+
+func parseProperties(props map[string]interface{}) routeProperties {
+	get := func(key string) string {
+		value, _ := props[key].(string)
+		return value
+	}
+	return routeProperties{
+		firewallArn:     get("FirewallArn"),
+		subnetAz:        get("SubnetAz"),
+		subnetAzId:      get("SubnetAzId"),
+		routeTableId:    get("RouteTableId"),
+		destinationCidr: get("DestinationCidr"),
+	}
+}
+
+// IPv6 destinations go into the IPv6 fields of the route calls.
+func (p routeProperties) ipv6() bool {
+	return strings.Contains(p.destinationCidr, ":")
+}
+
+// A route is identified by its route table and destination.
+func (p routeProperties) physicalId() string {
+	return fmt.Sprintf("%s|%s", p.routeTableId, p.destinationCidr)
+}
+
+// Records when the request started. The route itself is created by
+// isComplete once the firewall endpoints are ready. Errors are returned so
+// the provider framework reports them to CloudFormation.
+func (h *handler) onEvent(ctx context.Context, event cfn.Event) (response, error) {
+	log.Printf("%s request for %s", event.RequestType, event.LogicalResourceID)
+	props := parseProperties(event.ResourceProperties)
+	data := map[string]interface{}{"StartTime": h.now().UTC().Format(time.RFC3339)}
+
+	switch event.RequestType {
+	case cfn.RequestCreate:
+		return response{PhysicalResourceId: props.physicalId(), Data: data}, nil
+
+	case cfn.RequestUpdate:
+		// A new route table or destination is a new route. Returning a new
+		// physical ID makes CloudFormation send a Delete for the old route
+		// once the update has completed. Otherwise the route is pointed at
+		// the current endpoint in place and keeps its physical ID, which also
+		// keeps resources created with the log stream name as physical ID
+		// from deleting the route they still own.
+		if parseProperties(event.OldResourceProperties).physicalId() != props.physicalId() {
+			return response{PhysicalResourceId: props.physicalId(), Data: data}, nil
+		}
+		return response{PhysicalResourceId: event.PhysicalResourceID, Data: data}, nil
+
+	case cfn.RequestDelete:
+		// The route is taken from the properties, as older resources used
+		// the log stream name as physical ID.
+		if err := h.deleteRoute(ctx, props); err != nil {
+			return response{}, err
+		}
+		return response{PhysicalResourceId: event.PhysicalResourceID}, nil
+	}
+
+	return response{}, fmt.Errorf("unknown request type %q", event.RequestType)
+}
+
+// The provider framework merges the onEvent response into the event passed
+// to isComplete.
+type isCompleteEvent struct {
+	cfn.Event
+	Data map[string]string `json:"Data"`
+}
+
+type isCompleteResponse struct {
+	IsComplete bool `json:"IsComplete"`
+}
+
+// Waits until the firewall endpoints are ready, then creates the route.
+func (h *handler) isComplete(ctx context.Context, event isCompleteEvent) (isCompleteResponse, error) {
+	if event.RequestType == cfn.RequestDelete {
+		return isCompleteResponse{IsComplete: true}, nil
+	}
+
+	props := parseProperties(event.ResourceProperties)
+	startTime, err := time.Parse(time.RFC3339, event.Data["StartTime"])
+	if err != nil {
+		return isCompleteResponse{}, fmt.Errorf("parsing start time: %w", err)
+	}
+	timeoutMinutes, err := strconv.ParseFloat(fmt.Sprint(event.ResourceProperties["ReadinessTimeoutMinutes"]), 64)
+	if err != nil {
+		return isCompleteResponse{}, fmt.Errorf("parsing readiness timeout: %w", err)
+	}
+
+	endpointId, notReady, err := h.endpointId(ctx, props)
+	if err != nil {
+		return isCompleteResponse{}, err
+	}
+	if len(notReady) > 0 {
+		timeout := time.Duration(timeoutMinutes * float64(time.Minute))
+		if h.now().Sub(startTime) >= timeout {
+			return isCompleteResponse{}, fmt.Errorf("firewall %s endpoints not READY after %s: %s",
+				props.firewallArn, timeout, strings.Join(notReady, ", "))
+		}
+		log.Printf("Waiting for firewall endpoints: %s", strings.Join(notReady, ", "))
+		return isCompleteResponse{IsComplete: false}, nil
+	}
+
+	if err := h.upsertRoute(ctx, props, endpointId); err != nil {
+		return isCompleteResponse{}, err
+	}
+	return isCompleteResponse{IsComplete: true}, nil
+}
+
+// Returns the endpoint in the subnet AZ once the attachments in every AZ of
+// the firewall are READY. Otherwise returns the AZs that are not ready, with
+// their status.
+func (h *handler) endpointId(ctx context.Context, props routeProperties) (string, []string, error) {
+	out, err := h.nfw.DescribeFirewall(ctx, &networkfirewall.DescribeFirewallInput{
+		FirewallArn: aws.String(props.firewallArn),
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("describing firewall %s: %w", props.firewallArn, err)
+	}
+
+	var syncStates map[string]types.SyncState
+	if out.FirewallStatus != nil {
+		syncStates = out.FirewallStatus.SyncStates
+	}
+
+	subnetAz, err := h.subnetAzName(ctx, props)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var notReady []string
+	if _, ok := syncStates[subnetAz]; !ok {
+		notReady = append(notReady, fmt.Sprintf("%s (no endpoint)", subnetAz))
+	}
+	azs := make([]string, 0, len(syncStates))
+	for az := range syncStates {
+		azs = append(azs, az)
+	}
+	sort.Strings(azs)
+	for _, az := range azs {
+		attachment := syncStates[az].Attachment
+		if attachment == nil {
+			notReady = append(notReady, fmt.Sprintf("%s (no attachment)", az))
+			continue
+		}
+		if attachment.Status != types.AttachmentStatusReady || attachment.EndpointId == nil {
+			notReady = append(notReady, fmt.Sprintf("%s (%s)", az, attachment.Status))
+		}
+	}
+	if len(notReady) > 0 {
+		return "", notReady, nil
+	}
+
+	return *syncStates[subnetAz].Attachment.EndpointId, nil, nil
+}
+
+// The firewall reports its endpoints by AZ name. When the subnet AZ is given
+// as AZ ID, it is resolved to the AZ name of this account.
+func (h *handler) subnetAzName(ctx context.Context, props routeProperties) (string, error) {
+	if props.subnetAzId == "" {
+		return props.subnetAz, nil
+	}
+
+	out, err := h.ec2.DescribeAvailabilityZones(ctx, &ec2.DescribeAvailabilityZonesInput{
+		ZoneIds: []string{props.subnetAzId},
+	})
+	if err != nil {
+		return "", fmt.Errorf("describing AZ %s: %w", props.subnetAzId, err)
+	}
+	if len(out.AvailabilityZones) == 0 {
+		return "", fmt.Errorf("AZ %s not found", props.subnetAzId)
+	}
+	return aws.ToString(out.AvailabilityZones[0].ZoneName), nil
+}
+
+// Points the route at the firewall endpoint in the subnet AZ. Creating a
+// route that already exists replaces it, so retries are idempotent.
+func (h *handler) upsertRoute(ctx context.Context, props routeProperties, endpointId string) error {
+	create := &ec2.CreateRouteInput{
+		RouteTableId:  aws.String(props.routeTableId),
+		VpcEndpointId: aws.String(endpointId),
+	}
+	if props.ipv6() {
+		create.DestinationIpv6CidrBlock = aws.String(props.destinationCidr)
+	} else {
+		create.DestinationCidrBlock = aws.String(props.destinationCidr)
+	}
+	_, err := h.ec2.CreateRoute(ctx, create)
+	if err == nil {
+		log.Printf("Created route %s to %s", props.physicalId(), endpointId)
+		return nil
+	}
+	if errorCode(err) != "RouteAlreadyExists" {
+		return fmt.Errorf("creating route %s: %w", props.physicalId(), err)
+	}
+
+	replace := &ec2.ReplaceRouteInput{
+		RouteTableId:  aws.String(props.routeTableId),
+		VpcEndpointId: aws.String(endpointId),
+	}
+	if props.ipv6() {
+		replace.DestinationIpv6CidrBlock = aws.String(props.destinationCidr)
+	} else {
+		replace.DestinationCidrBlock = aws.String(props.destinationCidr)
+	}
+	_, err = h.ec2.ReplaceRoute(ctx, replace)
+	if err != nil {
+		return fmt.Errorf("replacing route %s: %w", props.physicalId(), err)
+	}
+	log.Printf("Replaced route %s to %s", props.physicalId(), endpointId)
+	return nil
+}
+
+func (h *handler) deleteRoute(ctx context.Context, props routeProperties) error {
+	input := &ec2.DeleteRouteInput{RouteTableId: aws.String(props.routeTableId)}
+	if props.ipv6() {
+		input.DestinationIpv6CidrBlock = aws.String(props.destinationCidr)
+	} else {
+		input.DestinationCidrBlock = aws.String(props.destinationCidr)
+	}
+	_, err := h.ec2.DeleteRoute(ctx, input)
+	if err != nil && errorCode(err) != "InvalidRoute.NotFound" {
+		return fmt.Errorf("deleting route %s: %w", props.physicalId(), err)
+	}
+	if err != nil {
+		log.Printf("Route %s already deleted", props.physicalId())
+		return nil
+	}
+	log.Printf("Deleted route %s", props.physicalId())
+	return nil
+}
+
+func errorCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return ""
+}
@@ -0,0 +1,389 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/cfn"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/networkfirewall"
+	"github.com/aws/aws-sdk-go-v2/service/networkfirewall/types"
+	"github.com/aws/smithy-go"
+)
+
+type fakeFirewall struct {
+	syncStates map[string]types.SyncState
+}
+
+func (f *fakeFirewall) DescribeFirewall(ctx context.Context, params *networkfirewall.DescribeFirewallInput, optFns ...func(*networkfirewall.Options)) (*networkfirewall.DescribeFirewallOutput, error) {
+	return &networkfirewall.DescribeFirewallOutput{
+		FirewallStatus: &types.FirewallStatus{SyncStates: f.syncStates},
+	}, nil
+}
+
+// Records the route calls. The error codes are returned by the matching
+// call when set.
+type fakeEc2 struct {
+	createErr string
+	deleteErr string
+	azNames   map[string]string
+	calls     []string
+}
+
+func apiError(code string) error {
+	if code == "" {
+		return nil
+	}
+	return &smithy.GenericAPIError{Code: code}
+}
+
+func (f *fakeEc2) CreateRoute(ctx context.Context, params *ec2.CreateRouteInput, optFns ...func(*ec2.Options)) (*ec2.CreateRouteOutput, error) {
+	f.calls = append(f.calls, "create "+aws.ToString(params.RouteTableId)+" "+aws.ToString(params.DestinationCidrBlock)+aws.ToString(params.DestinationIpv6CidrBlock)+" "+aws.ToString(params.VpcEndpointId))
+	return &ec2.CreateRouteOutput{}, apiError(f.createErr)
+}
+
+func (f *fakeEc2) ReplaceRoute(ctx context.Context, params *ec2.ReplaceRouteInput, optFns ...func(*ec2.Options)) (*ec2.ReplaceRouteOutput, error) {
+	f.calls = append(f.calls, "replace "+aws.ToString(params.RouteTableId)+" "+aws.ToString(params.DestinationCidrBlock)+aws.ToString(params.DestinationIpv6CidrBlock)+" "+aws.ToString(params.VpcEndpointId))
+	return &ec2.ReplaceRouteOutput{}, nil
+}
+
+func (f *fakeEc2) DeleteRoute(ctx context.Context, params *ec2.DeleteRouteInput, optFns ...func(*ec2.Options)) (*ec2.DeleteRouteOutput, error) {
+	f.calls = append(f.calls, "delete "+aws.ToString(params.RouteTableId)+" "+aws.ToString(params.DestinationCidrBlock)+aws.ToString(params.DestinationIpv6CidrBlock))
+	return &ec2.DeleteRouteOutput{}, apiError(f.deleteErr)
+}
+
+func (f *fakeEc2) DescribeAvailabilityZones(ctx context.Context, params *ec2.DescribeAvailabilityZonesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeAvailabilityZonesOutput, error) {
+	var azs []ec2types.AvailabilityZone
+	for _, id := range params.ZoneIds {
+		if name, ok := f.azNames[id]; ok {
+			azs = append(azs, ec2types.AvailabilityZone{ZoneId: aws.String(id), ZoneName: aws.String(name)})
+		}
+	}
+	return &ec2.DescribeAvailabilityZonesOutput{AvailabilityZones: azs}, nil
+}
+
+func readyAttachment(endpointId string) types.SyncState {
+	return types.SyncState{Attachment: &types.Attachment{
+		Status:     types.AttachmentStatusReady,
+		EndpointId: aws.String(endpointId),
+	}}
+}
+
+func routeProps(routeTableId, destinationCidr string) map[string]interface{} {
+	return map[string]interface{}{
+		"FirewallArn":             "arn:aws:network-firewall:eu-west-1:111111111111:firewall/fw",
+		"SubnetAz":                "eu-west-1a",
+		"RouteTableId":            routeTableId,
+		"DestinationCidr":         destinationCidr,
+		"ReadinessTimeoutMinutes": "30",
+	}
+}
+
+func TestOnEvent(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		event      cfn.Event
+		deleteErr  string
+		wantId     string
+		wantCalls  []string
+		wantErr    bool
+		wantNoData bool
+	}{
+		{
+			name: "create",
+			event: cfn.Event{
+				RequestType:        cfn.RequestCreate,
+				ResourceProperties: routeProps("rtb-1", "10.0.0.0/8"),
+			},
+			wantId: "rtb-1|10.0.0.0/8",
+		},
+		{
+			name: "update with same route keeps the physical ID",
+			event: cfn.Event{
+				RequestType:           cfn.RequestUpdate,
+				PhysicalResourceID:    "legacy-log-stream",
+				ResourceProperties:    routeProps("rtb-1", "10.0.0.0/8"),
+				OldResourceProperties: routeProps("rtb-1", "10.0.0.0/8"),
+			},
+			wantId: "legacy-log-stream",
+		},
+		{
+			name: "update with changed route returns a new physical ID",
+			event: cfn.Event{
+				RequestType:           cfn.RequestUpdate,
+				PhysicalResourceID:    "rtb-1|10.0.0.0/8",
+				ResourceProperties:    routeProps("rtb-2", "10.0.0.0/8"),
+				OldResourceProperties: routeProps("rtb-1", "10.0.0.0/8"),
+			},
+			wantId: "rtb-2|10.0.0.0/8",
+		},
+		{
+			name: "delete",
+			event: cfn.Event{
+				RequestType:        cfn.RequestDelete,
+				PhysicalResourceID: "rtb-1|10.0.0.0/8",
+				ResourceProperties: routeProps("rtb-1", "10.0.0.0/8"),
+			},
+			wantId:     "rtb-1|10.0.0.0/8",
+			wantCalls:  []string{"delete rtb-1 10.0.0.0/8"},
+			wantNoData: true,
+		},
+		{
+			name: "delete of a missing route succeeds",
+			event: cfn.Event{
+				RequestType:        cfn.RequestDelete,
+				PhysicalResourceID: "rtb-1|::/0",
+				ResourceProperties: routeProps("rtb-1", "::/0"),
+			},
+			deleteErr:  "InvalidRoute.NotFound",
+			wantId:     "rtb-1|::/0",
+			wantCalls:  []string{"delete rtb-1 ::/0"},
+			wantNoData: true,
+		},
+		{
+			name: "delete fails on other errors",
+			event: cfn.Event{
+				RequestType:        cfn.RequestDelete,
+				PhysicalResourceID: "rtb-1|10.0.0.0/8",
+				ResourceProperties: routeProps("rtb-1", "10.0.0.0/8"),
+			},
+			deleteErr: "UnauthorizedOperation",
+			wantCalls: []string{"delete rtb-1 10.0.0.0/8"},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ec2Client := &fakeEc2{deleteErr: tt.deleteErr}
+			h := &handler{nfw: &fakeFirewall{}, ec2: ec2Client, now: func() time.Time { return now }}
+
+			resp, err := h.onEvent(context.Background(), tt.event)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("onEvent error = %v, want error %v", err, tt.wantErr)
+			}
+			if !slices.Equal(ec2Client.calls, tt.wantCalls) {
+				t.Errorf("calls = %q, want %q", ec2Client.calls, tt.wantCalls)
+			}
+			if tt.wantErr {
+				return
+			}
+			if resp.PhysicalResourceId != tt.wantId {
+				t.Errorf("PhysicalResourceId = %q, want %q", resp.PhysicalResourceId, tt.wantId)
+			}
+			if tt.wantNoData != (resp.Data == nil) {
+				t.Errorf("Data = %v", resp.Data)
+			}
+			if !tt.wantNoData && resp.Data["StartTime"] != "2026-01-01T12:00:00Z" {
+				t.Errorf("StartTime = %v", resp.Data["StartTime"])
+			}
+		})
+	}
+}
+
+func TestIsCompleteUpsertsRoute(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		props     map[string]interface{}
+		createErr string
+		wantCalls []string
+		wantErr   bool
+	}{
+		{
+			name:      "creates the route",
+			props:     routeProps("rtb-1", "10.0.0.0/8"),
+			wantCalls: []string{"create rtb-1 10.0.0.0/8 vpce-a"},
+		},
+		{
+			name:      "creates an IPv6 route",
+			props:     routeProps("rtb-1", "::/0"),
+			wantCalls: []string{"create rtb-1 ::/0 vpce-a"},
+		},
+		{
+			name:      "replaces an existing route",
+			props:     routeProps("rtb-1", "10.0.0.0/8"),
+			createErr: "RouteAlreadyExists",
+			wantCalls: []string{"create rtb-1 10.0.0.0/8 vpce-a", "replace rtb-1 10.0.0.0/8 vpce-a"},
+		},
+		{
+			name:      "fails on other errors",
+			props:     routeProps("rtb-1", "10.0.0.0/8"),
+			createErr: "InvalidRouteTableID.NotFound",
+			wantCalls: []string{"create rtb-1 10.0.0.0/8 vpce-a"},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ec2Client := &fakeEc2{createErr: tt.createErr}
+			h := &handler{
+				nfw: &fakeFirewall{syncStates: map[string]types.SyncState{
+					"eu-west-1a": readyAttachment("vpce-a"),
+					"eu-west-1b": readyAttachment("vpce-b"),
+				}},
+				ec2: ec2Client,
+				now: func() time.Time { return now },
+			}
+
+			resp, err := h.isComplete(context.Background(), isCompleteEvent{
+				Event: cfn.Event{RequestType: cfn.RequestCreate, ResourceProperties: tt.props},
+				Data:  map[string]string{"StartTime": now.Format(time.RFC3339)},
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("isComplete error = %v, want error %v", err, tt.wantErr)
+			}
+			if !slices.Equal(ec2Client.calls, tt.wantCalls) {
+				t.Errorf("calls = %q, want %q", ec2Client.calls, tt.wantCalls)
+			}
+			if !tt.wantErr && !resp.IsComplete {
+				t.Error("IsComplete = false, want true")
+			}
+		})
+	}
+}
+
+func TestIsCompleteReadiness(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		syncStates   map[string]types.SyncState
+		subnetAzId   string
+		elapsed      time.Duration
+		wantComplete bool
+		wantCalls    []string
+		wantErr      bool
+	}{
+		{
+			name: "routes to the endpoint in the subnet AZ once every AZ is READY",
+			syncStates: map[string]types.SyncState{
+				"eu-west-1a": readyAttachment("vpce-a"),
+				"eu-west-1b": readyAttachment("vpce-b"),
+			},
+			wantComplete: true,
+			wantCalls:    []string{"create rtb-1 10.0.0.0/8 vpce-a"},
+		},
+		{
+			name: "resolves the subnet AZ ID to the AZ name",
+			syncStates: map[string]types.SyncState{
+				"eu-west-1a": readyAttachment("vpce-a"),
+				"eu-west-1b": readyAttachment("vpce-b"),
+			},
+			subnetAzId:   "euw1-az2",
+			wantComplete: true,
+			wantCalls:    []string{"create rtb-1 10.0.0.0/8 vpce-b"},
+		},
+		{
+			name: "waits for an AZ that is not READY",
+			syncStates: map[string]types.SyncState{
+				"eu-west-1a": readyAttachment("vpce-a"),
+				"eu-west-1b": {Attachment: &types.Attachment{Status: types.AttachmentStatusCreating}},
+			},
+			elapsed: 5 * time.Minute,
+		},
+		{
+			name: "waits for an AZ without attachment",
+			syncStates: map[string]types.SyncState{
+				"eu-west-1a": readyAttachment("vpce-a"),
+				"eu-west-1b": {},
+			},
+			elapsed: 5 * time.Minute,
+		},
+		{
+			name: "waits when the subnet AZ has no endpoint",
+			syncStates: map[string]types.SyncState{
+				"eu-west-1b": readyAttachment("vpce-b"),
+			},
+			elapsed: 5 * time.Minute,
+		},
+		{
+			name: "fails once the readiness timeout expired",
+			syncStates: map[string]types.SyncState{
+				"eu-west-1a": readyAttachment("vpce-a"),
+				"eu-west-1b": {Attachment: &types.Attachment{Status: types.AttachmentStatusCreating}},
+			},
+			elapsed: 30 * time.Minute,
+			wantErr: true,
+		},
+		{
+			name: "fails once the readiness timeout expired without endpoint in the subnet AZ",
+			syncStates: map[string]types.SyncState{
+				"eu-west-1b": readyAttachment("vpce-b"),
+			},
+			elapsed: 31 * time.Minute,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ec2Client := &fakeEc2{azNames: map[string]string{"euw1-az1": "eu-west-1a", "euw1-az2": "eu-west-1b"}}
+			h := &handler{
+				nfw: &fakeFirewall{syncStates: tt.syncStates},
+				ec2: ec2Client,
+				now: func() time.Time { return start.Add(tt.elapsed) },
+			}
+			props := routeProps("rtb-1", "10.0.0.0/8")
+			if tt.subnetAzId != "" {
+				props["SubnetAzId"] = tt.subnetAzId
+			}
+
+			resp, err := h.isComplete(context.Background(), isCompleteEvent{
+				Event: cfn.Event{RequestType: cfn.RequestCreate, ResourceProperties: props},
+				Data:  map[string]string{"StartTime": start.Format(time.RFC3339)},
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("isComplete error = %v, want error %v", err, tt.wantErr)
+			}
+			if resp.IsComplete != tt.wantComplete {
+				t.Errorf("IsComplete = %v, want %v", resp.IsComplete, tt.wantComplete)
+			}
+			if !slices.Equal(ec2Client.calls, tt.wantCalls) {
+				t.Errorf("calls = %q, want %q", ec2Client.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestEndpointIdReportsNotReadyAzs(t *testing.T) {
+	h := &handler{
+		nfw: &fakeFirewall{syncStates: map[string]types.SyncState{
+			"eu-west-1b": {Attachment: &types.Attachment{Status: types.AttachmentStatusCreating}},
+			"eu-west-1c": {},
+		}},
+		ec2: &fakeEc2{},
+	}
+
+	endpointId, notReady, err := h.endpointId(context.Background(), parseProperties(routeProps("rtb-1", "10.0.0.0/8")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"eu-west-1a (no endpoint)", "eu-west-1b (CREATING)", "eu-west-1c (no attachment)"}
+	if endpointId != "" || !slices.Equal(notReady, want) {
+		t.Errorf("endpointId = %q, %q, want \"\", %q", endpointId, notReady, want)
+	}
+}